@@ -23,6 +23,12 @@ func init() {
 	cfg.v.SetDefault("name", "gokit")
 	cfg.v.SetDefault("server.port", 8080)
 	cfg.v.SetDefault("server.debug", true)
+	cfg.v.SetDefault("server.shutdownTimeout", "10s")
+	cfg.v.SetDefault("server.methodPrefix", false)
+	cfg.v.SetDefault("server.httpStatus", false)
+	cfg.v.SetDefault("server.strict", false)
+	cfg.v.SetDefault("server.openapi.path", "")
+	cfg.v.SetDefault("rpc.shutdownTimeout", "10s")
 	cfg.v.SetDefault("logger.level", "debug")
 	cfg.v.SetDefault("logger.type", "text")
 	cfg.v.SetDefault("logger.stack", "panic")
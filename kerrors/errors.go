@@ -0,0 +1,107 @@
+package kerrors
+
+import (
+	"errors"
+	"fmt"
+	jsoniter "github.com/json-iterator/go"
+)
+
+// MetadataKey is the rpc metadata key carrying an encoded Error.
+const MetadataKey = "__kit_error"
+
+// Error is an error with a code shared by the http and rpc transports. The
+// http server uses Code as Response.Status, rpc sends the whole error as
+// metadata and rebuilds it on the client.
+type Error struct {
+	Code      int            `json:"code"`
+	Message   string         `json:"message"`
+	Details   map[string]any `json:"details,omitempty"`
+	Retryable bool           `json:"retryable,omitempty"`
+	cause     error
+}
+
+func New(code int, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+func Newf(code int, format string, args ...any) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// Wrap returns an Error with code and message caused by err. The cause is
+// kept for errors.Is/As in process but does not travel over rpc.
+func Wrap(err error, code int, message string) *Error {
+	return &Error{Code: code, Message: message, cause: err}
+}
+
+func (this *Error) Error() string {
+	if this.cause != nil {
+		return fmt.Sprintf("%s: %s", this.Message, this.cause.Error())
+	}
+	return this.Message
+}
+
+func (this *Error) Unwrap() error {
+	return this.cause
+}
+
+// WithDetail returns a copy of the error with key set in its details.
+func (this *Error) WithDetail(key string, value any) *Error {
+	e := *this
+	e.Details = make(map[string]any, len(this.Details)+1)
+	for k, v := range this.Details {
+		e.Details[k] = v
+	}
+	e.Details[key] = value
+	return &e
+}
+
+// WithRetryable returns a copy of the error telling callers whether the call
+// may succeed when sent again.
+func (this *Error) WithRetryable(retryable bool) *Error {
+	e := *this
+	e.Retryable = retryable
+	return &e
+}
+
+// Encode returns the error as carried in rpc metadata.
+func (this *Error) Encode() string {
+	str, _ := jsoniter.MarshalToString(this)
+	return str
+}
+
+// Decode rebuilds an Error from Encode's output.
+func Decode(str string) (*Error, error) {
+	e := &Error{}
+	if err := jsoniter.UnmarshalFromString(str, e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// FromError returns the Error in err's chain.
+func FromError(err error) (*Error, bool) {
+	var e *Error
+	if errors.As(err, &e) {
+		return e, true
+	}
+	return nil, false
+}
+
+// Code returns the code of the Error in err's chain, 0 for nil and
+// fallback for any other error.
+func Code(err error, fallback int) int {
+	if err == nil {
+		return 0
+	}
+	if e, ok := FromError(err); ok {
+		return e.Code
+	}
+	return fallback
+}
+
+// IsRetryable reports whether err carries a retryable Error.
+func IsRetryable(err error) bool {
+	e, ok := FromError(err)
+	return ok && e.Retryable
+}
@@ -0,0 +1,38 @@
+package kerrors
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{name: "nil", err: nil, want: 0},
+		{name: "plain", err: errors.New("boom"), want: 500},
+		{name: "coded", err: New(404, "not found"), want: 404},
+		{name: "wrapped", err: fmt.Errorf("load: %w", New(409, "conflict")), want: 409},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Code(tt.err, 500); got != tt.want {
+				t.Errorf("Code() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncode(t *testing.T) {
+	e := New(429, "slow down").WithDetail("after", "1s").WithRetryable(true)
+	got, err := Decode(e.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Code != 429 || got.Message != "slow down" || !got.Retryable || got.Details["after"] != "1s" {
+		t.Errorf("Decode() = %+v, want %+v", got, e)
+	}
+}
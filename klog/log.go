@@ -7,6 +7,7 @@ import (
 	"go.uber.org/zap/zapcore"
 	"os"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -26,7 +27,15 @@ var encoderConfig = zapcore.EncoderConfig{
 	EncodeCaller:   zapcore.ShortCallerEncoder,
 }
 
-var logger *zapLogger
+var (
+	logger     *zapLogger
+	loggerOnce sync.Once
+)
+
+func getLogger() *zapLogger {
+	loggerOnce.Do(initialize)
+	return logger
+}
 
 type zapLogger struct {
 	lg   *zap.SugaredLogger
@@ -34,10 +43,15 @@ type zapLogger struct {
 	args []any
 }
 
+// Args returns a logger adding key/value pairs to the ones of this. Loggers
+// are never changed once built, so they can be shared between goroutines.
+func (this *zapLogger) Args(args ...any) *zapLogger {
+	merged := make([]any, 0, len(this.args)+len(args))
+	merged = append(append(merged, this.args...), args...)
+	return &zapLogger{lg: this.lg, atom: this.atom, args: merged}
+}
+
 func (this *zapLogger) Debug(msg ...string) {
-	defer func() {
-		this.args = []any{}
-	}()
 	str := ""
 	if len(msg) > 0 {
 		str = msg[0]
@@ -47,16 +61,10 @@ func (this *zapLogger) Debug(msg ...string) {
 }
 
 func (this *zapLogger) Debugf(format string, args ...any) {
-	defer func() {
-		this.args = []any{}
-	}()
 	this.lg.With(this.args...).Debugf(format, args...)
 }
 
 func (this *zapLogger) Info(msg ...string) {
-	defer func() {
-		this.args = []any{}
-	}()
 	str := ""
 	if len(msg) > 0 {
 		str = msg[0]
@@ -65,16 +73,10 @@ func (this *zapLogger) Info(msg ...string) {
 }
 
 func (this *zapLogger) Infof(format string, args ...any) {
-	defer func() {
-		this.args = []any{}
-	}()
 	this.lg.With(this.args...).Infof(format, args...)
 }
 
 func (this *zapLogger) Warn(msg ...string) {
-	defer func() {
-		this.args = []any{}
-	}()
 	str := ""
 	if len(msg) > 0 {
 		str = msg[0]
@@ -83,16 +85,10 @@ func (this *zapLogger) Warn(msg ...string) {
 }
 
 func (this *zapLogger) Warnf(format string, args ...any) {
-	defer func() {
-		this.args = []any{}
-	}()
 	this.lg.With(this.args...).Warnf(format, args...)
 }
 
 func (this *zapLogger) Error(msg ...string) {
-	defer func() {
-		this.args = []any{}
-	}()
 	str := ""
 	if len(msg) > 0 {
 		str = msg[0]
@@ -101,16 +97,10 @@ func (this *zapLogger) Error(msg ...string) {
 }
 
 func (this *zapLogger) Errof(format string, args ...any) {
-	defer func() {
-		this.args = []any{}
-	}()
-	this.lg.With(this.args).Errorf(format, args...)
+	this.lg.With(this.args...).Errorf(format, args...)
 }
 
 func (this *zapLogger) Panic(msg ...string) {
-	defer func() {
-		this.args = []any{}
-	}()
 	str := ""
 	if len(msg) > 0 {
 		str = msg[0]
@@ -119,16 +109,10 @@ func (this *zapLogger) Panic(msg ...string) {
 }
 
 func (this *zapLogger) Panicf(format string, args ...any) {
-	defer func() {
-		this.args = []any{}
-	}()
-	this.lg.With(this.args).Panicf(format, args...)
+	this.lg.With(this.args...).Panicf(format, args...)
 }
 
 func (this *zapLogger) Fatal(msg ...string) {
-	defer func() {
-		this.args = []any{}
-	}()
 	str := ""
 	if len(msg) > 0 {
 		str = msg[0]
@@ -137,16 +121,10 @@ func (this *zapLogger) Fatal(msg ...string) {
 }
 
 func (this *zapLogger) Fatalf(format string, args ...any) {
-	defer func() {
-		this.args = []any{}
-	}()
 	this.lg.With(this.args...).Fatalf(format, args...)
 }
 
 func (this *zapLogger) Dump(keysAndValues ...any) {
-	defer func() {
-		this.args = []any{}
-	}()
 	arr := coupArray(keysAndValues)
 	for k, v := range arr {
 		if k%2 == 0 {
@@ -155,7 +133,7 @@ func (this *zapLogger) Dump(keysAndValues ...any) {
 			arr[k] = strings.Replace(spew.Sdump(v), "\n", "", -1)
 		}
 	}
-	logger.lg.With(arr...).Debug("Dump")
+	this.lg.With(this.args...).With(arr...).Debug("Dump")
 }
 
 func initialize() {
@@ -197,7 +175,7 @@ func (l *zapLogger) init() {
 	l.atom = atom
 }
 
-//拼接完整的数组
+// 拼接完整的数组
 func coupArray(kv []interface{}) []interface{} {
 	if len(kv)%2 != 0 {
 		kv = append(kv, kv[len(kv)-1])
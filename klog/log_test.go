@@ -0,0 +1,50 @@
+package klog
+
+import (
+	"context"
+	"github.com/xinzf/kit/ktrace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	"sync"
+	"testing"
+)
+
+func TestWithContextConcurrent(t *testing.T) {
+	core, logs := observer.New(zap.DebugLevel)
+	loggerOnce.Do(func() {})
+	logger = &zapLogger{lg: zap.New(core).Sugar()}
+
+	contexts := map[string]context.Context{
+		"a": ktrace.NewContext(context.Background(), ktrace.Start("request-a", "")),
+		"b": ktrace.NewContext(context.Background(), ktrace.Start("request-b", "")),
+	}
+
+	var wg sync.WaitGroup
+	for name, ctx := range contexts {
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func(name string, ctx context.Context) {
+				defer wg.Done()
+				WithContext(ctx).Args("who", name).Info(name)
+				Info("plain")
+			}(name, ctx)
+		}
+	}
+	wg.Wait()
+
+	if logs.Len() != 200 {
+		t.Fatalf("logged %d entries", logs.Len())
+	}
+	for _, entry := range logs.All() {
+		fields := entry.ContextMap()
+		if entry.Message == "plain" {
+			if len(fields) != 0 {
+				t.Errorf("plain message has fields %v", fields)
+			}
+			continue
+		}
+		if fields["request_id"] != "request-"+entry.Message || fields["who"] != entry.Message {
+			t.Errorf("message %s has fields %v", entry.Message, fields)
+		}
+	}
+}
@@ -1,166 +1,109 @@
 package klog
 
 import (
+	"context"
 	"github.com/davecgh/go-spew/spew"
+	"github.com/xinzf/kit/ktrace"
 	"strings"
 )
 
+// Args returns a logger adding key/value pairs to its messages.
 func Args(args ...any) *zapLogger {
-	if logger == nil {
-		initialize()
-	}
-	logger.args = args
-	return logger
+	return getLogger().Args(args...)
+}
+
+// WithContext returns a logger adding the request id and trace of ctx to its
+// messages. More pairs can be added with Args: klog.WithContext(ctx).Args("k", v).
+func WithContext(ctx context.Context) *zapLogger {
+	return Args(ktrace.Fields(ctx)...)
 }
 
 func Debug(msg ...string) {
-	if logger == nil {
-		initialize()
-	}
-	defer func() {
-		logger.args = []any{}
-	}()
+	lg := getLogger().lg
 	str := ""
 	if len(msg) > 0 {
 		str = msg[0]
 	}
-	logger.lg.With(logger.args...).Debug(str)
+	lg.Debug(str)
 }
 
 func Debugf(format string, args ...interface{}) {
-	if logger == nil {
-		initialize()
-	}
-	defer func() {
-		logger.args = []any{}
-	}()
-	logger.lg.With(logger.args...).Debugf(format, args...)
+	lg := getLogger().lg
+	lg.Debugf(format, args...)
 }
 
 func Info(msg ...string) {
-	if logger == nil {
-		initialize()
-	}
-	defer func() {
-		logger.args = []any{}
-	}()
+	lg := getLogger().lg
 	str := ""
 	if len(msg) > 0 {
 		str = msg[0]
 	}
-	logger.lg.With(logger.args...).Info(str)
+	lg.Info(str)
 }
 
 func Infof(format string, args ...interface{}) {
-	if logger == nil {
-		initialize()
-	}
-	defer func() {
-		logger.args = []any{}
-	}()
-	logger.lg.With(logger.args...).Infof(format, args...)
+	lg := getLogger().lg
+	lg.Infof(format, args...)
 }
 
 func Warn(msg ...string) {
-	if logger == nil {
-		initialize()
-	}
-	defer func() {
-		logger.args = []any{}
-	}()
+	lg := getLogger().lg
 	str := ""
 	if len(msg) > 0 {
 		str = msg[0]
 	}
-	logger.lg.Warnw(str, logger.args...)
+	lg.Warn(str)
 }
 
 func Warnf(format string, args ...interface{}) {
-	if logger == nil {
-		initialize()
-	}
-	defer func() {
-		logger.args = []any{}
-	}()
-	logger.lg.With(logger.args...).Warnf(format, args...)
+	lg := getLogger().lg
+	lg.Warnf(format, args...)
 }
 
 func Error(msg ...string) {
-	if logger == nil {
-		initialize()
-	}
-	defer func() {
-		logger.args = []any{}
-	}()
+	lg := getLogger().lg
 	str := ""
 	if len(msg) > 0 {
 		str = msg[0]
 	}
-	logger.lg.Errorw(str, logger.args...)
+	lg.Error(str)
 }
 
 func Errof(format string, args ...interface{}) {
-	if logger == nil {
-		initialize()
-	}
-	defer func() {
-		logger.args = []any{}
-	}()
-	logger.lg.With(logger.args).Errorf(format, args...)
+	lg := getLogger().lg
+	lg.Errorf(format, args...)
 }
 
 func Panic(msg ...string) {
-	if logger == nil {
-		initialize()
-	}
-	defer func() {
-		logger.args = []any{}
-	}()
+	lg := getLogger().lg
 	str := ""
 	if len(msg) > 0 {
 		str = msg[0]
 	}
-	logger.lg.Panicw(str, logger.args...)
+	lg.Panic(str)
 }
 
 func Panicf(format string, args ...interface{}) {
-	if logger == nil {
-		initialize()
-	}
-	defer func() {
-		logger.args = []any{}
-	}()
-	logger.lg.With(logger.args).Panicf(format, args...)
+	lg := getLogger().lg
+	lg.Panicf(format, args...)
 }
 
 func Fatal(msg ...string) {
-	if logger == nil {
-		initialize()
-	}
-	defer func() {
-		logger.args = []any{}
-	}()
+	lg := getLogger().lg
 	str := ""
 	if len(msg) > 0 {
 		str = msg[0]
 	}
-	logger.lg.Fatalw(str, logger.args...)
+	lg.Fatal(str)
 }
 
 func Fatalf(format string, args ...interface{}) {
-	if logger == nil {
-		initialize()
-	}
-	defer func() {
-		logger.args = []any{}
-	}()
-	logger.lg.With(logger.args...).Fatalf(format, args...)
+	lg := getLogger().lg
+	lg.Fatalf(format, args...)
 }
 
 func Dump(keysAndValues ...interface{}) {
-	if logger == nil {
-		initialize()
-	}
+	lg := getLogger().lg
 	arr := coupArray(keysAndValues)
 	for k, v := range arr {
 		if k%2 == 0 {
@@ -169,5 +112,5 @@ func Dump(keysAndValues ...interface{}) {
 			arr[k] = strings.Replace(spew.Sdump(v), "\n", "", -1)
 		}
 	}
-	logger.lg.With(arr...).Debug("Dump")
+	lg.With(arr...).Debug("Dump")
 }
@@ -0,0 +1,141 @@
+package ktrace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+const (
+	// RequestIdHeader carries the request id in http headers and rpc
+	// metadata.
+	RequestIdHeader = "X-Request-Id"
+	// TraceparentHeader carries the W3C trace context.
+	TraceparentHeader = "traceparent"
+)
+
+// Trace identifies a request across the services it goes through. TraceId
+// and SpanId follow the W3C trace context: every service handling the
+// request starts its own span, ParentId is the span of the caller.
+type Trace struct {
+	RequestId string
+	TraceId   string
+	SpanId    string
+	ParentId  string
+	Sampled   bool
+}
+
+// Traceparent returns the traceparent header making the receiver a child of
+// this span.
+func (this Trace) Traceparent() string {
+	flags := "00"
+	if this.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", this.TraceId, this.SpanId, flags)
+}
+
+// Metadata returns the headers or rpc metadata forwarding the trace.
+func (this Trace) Metadata() map[string]string {
+	return map[string]string{
+		RequestIdHeader:   this.RequestId,
+		TraceparentHeader: this.Traceparent(),
+	}
+}
+
+type ctxKey struct{}
+
+// NewContext returns a copy of ctx carrying t.
+func NewContext(ctx context.Context, t Trace) context.Context {
+	return context.WithValue(ctx, ctxKey{}, t)
+}
+
+// FromContext returns the trace of ctx.
+func FromContext(ctx context.Context) (Trace, bool) {
+	if ctx == nil {
+		return Trace{}, false
+	}
+	t, ok := ctx.Value(ctxKey{}).(Trace)
+	return t, ok
+}
+
+// Start begins the span of a request received with requestId and
+// traceparent. Missing or malformed values start a new request id or trace.
+func Start(requestId, traceparent string) Trace {
+	t := Trace{RequestId: requestId, SpanId: randomHex(8), Sampled: true}
+	if !validRequestId(requestId) {
+		t.RequestId = randomHex(16)
+	}
+	if traceId, parentId, sampled, ok := ParseTraceparent(traceparent); ok {
+		t.TraceId, t.ParentId, t.Sampled = traceId, parentId, sampled
+	} else {
+		t.TraceId = randomHex(16)
+	}
+	return t
+}
+
+// ParseTraceparent splits a version 00 traceparent header.
+func ParseTraceparent(header string) (traceId, parentId string, sampled bool, ok bool) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return "", "", false, false
+	}
+	if parts[0] == "00" && len(parts) != 4 {
+		return "", "", false, false
+	}
+	traceId, parentId = parts[1], parts[2]
+	if !isHex(traceId, 32) || !isHex(parentId, 16) || !isHex(parts[3], 2) {
+		return "", "", false, false
+	}
+	if strings.Trim(traceId, "0") == "" || strings.Trim(parentId, "0") == "" {
+		return "", "", false, false
+	}
+	flags, _ := hex.DecodeString(parts[3])
+	return traceId, parentId, flags[0]&1 == 1, true
+}
+
+// Fields returns the trace of ctx as klog key/value pairs, nil when ctx has
+// none.
+func Fields(ctx context.Context) []any {
+	t, ok := FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	return []any{
+		"request_id", t.RequestId,
+		"trace_id", t.TraceId,
+		"span_id", t.SpanId,
+	}
+}
+
+func validRequestId(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, ch := range id {
+		if ch < 0x21 || ch > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+func isHex(s string, length int) bool {
+	if len(s) != length {
+		return false
+	}
+	for _, ch := range s {
+		if !(ch >= '0' && ch <= '9' || ch >= 'a' && ch <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
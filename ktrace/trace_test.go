@@ -0,0 +1,58 @@
+package ktrace
+
+import (
+	"context"
+	"testing"
+)
+
+func TestStart(t *testing.T) {
+	parent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	tr := Start("req-1", parent)
+	if tr.RequestId != "req-1" || tr.TraceId != "4bf92f3577b34da6a3ce929d0e0e4736" || tr.ParentId != "00f067aa0ba902b7" || !tr.Sampled {
+		t.Errorf("Start() = %+v", tr)
+	}
+	if len(tr.SpanId) != 16 || tr.SpanId == tr.ParentId {
+		t.Errorf("Start() span = %q", tr.SpanId)
+	}
+
+	traceId, parentId, _, ok := ParseTraceparent(tr.Traceparent())
+	if !ok || traceId != tr.TraceId || parentId != tr.SpanId {
+		t.Errorf("Traceparent() = %q", tr.Traceparent())
+	}
+
+	tr = Start("bad id", "00-00000000000000000000000000000000-00f067aa0ba902b7-01")
+	if len(tr.RequestId) != 32 || len(tr.TraceId) != 32 || tr.ParentId != "" {
+		t.Errorf("Start() with invalid headers = %+v", tr)
+	}
+}
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		header string
+		ok     bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-extra", true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-extra", false},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", false},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-00", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-00", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if _, _, _, ok := ParseTraceparent(tt.header); ok != tt.ok {
+			t.Errorf("ParseTraceparent(%q) ok = %v, want %v", tt.header, ok, tt.ok)
+		}
+	}
+}
+
+func TestFields(t *testing.T) {
+	if Fields(context.Background()) != nil {
+		t.Error("Fields() of an untraced context should be nil")
+	}
+	ctx := NewContext(context.Background(), Trace{RequestId: "r", TraceId: "t", SpanId: "s"})
+	fields := Fields(ctx)
+	if len(fields) != 6 || fields[1] != "r" || fields[3] != "t" || fields[5] != "s" {
+		t.Errorf("Fields() = %v", fields)
+	}
+}
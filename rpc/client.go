@@ -2,36 +2,263 @@ package rpc
 
 import (
 	"context"
-	etcd_client "github.com/rpcxio/rpcx-etcd/client"
+	"crypto/tls"
+	"fmt"
 	"github.com/smallnest/rpcx/client"
 	rpcx_protocol "github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/share"
 	"github.com/xinzf/kit/container/kcfg"
 	"github.com/xinzf/kit/container/kvar"
+	"github.com/xinzf/kit/kerrors"
 	"github.com/xinzf/kit/klog"
+	kitServer "github.com/xinzf/kit/server"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
 )
 
-func Call(service, method string, req, rsp any) error {
+// ClientConfig controls how the cached client of a service talks to it.
+type ClientConfig struct {
+	FailMode       FailMode
+	SelectMode     SelectMode
+	SerializeType  rpcx_protocol.SerializeType
+	Retries        int
+	ConnectTimeout time.Duration
+	// Timeout bounds every call made without its own deadline, 0 means none.
+	Timeout time.Duration
+	// TLSConfig secures the connections to tcp nodes and is required for
+	// quic ones, which rpcx would otherwise dial without verifying them.
+	TLSConfig *tls.Config
+	// Block is the kcp.BlockCrypt shared with the kcp nodes, which are only
+	// reached by binaries built with the kcp tag, as quic needs the quic tag.
+	Block any
+}
+
+// key identifies the cached client of service. Per-call values, such as the
+// hash key and metadata, travel in the call context instead.
+func (this ClientConfig) key(service string) string {
+	return fmt.Sprintf("%s|%d|%d|%d|%d|%s|%p|%x", service, this.FailMode, this.SelectMode, this.SerializeType, this.Retries, this.ConnectTimeout,
+		this.TLSConfig, pointerOf(this.Block))
+}
+
+// pointerOf returns the address held by v, 0 when it holds no pointer.
+func pointerOf(v any) uintptr {
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Ptr {
+		return rv.Pointer()
+	}
+	return 0
+}
+
+var (
+	clientsMu     sync.Mutex
+	clients       = map[string]client.XClient{}
+	clientConfigs = map[string]ClientConfig{}
+	closeOnce     sync.Once
+)
+
+// ConfigureClient overrides the rpc.clients config of service. It only
+// affects clients created afterwards. Services served over quic or kcp need
+// it to set TLSConfig or Block, which can't be read from the config.
+func ConfigureClient(service string, cfg ClientConfig) {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	clientConfigs[service] = cfg
+}
+
+// Client returns the cached client of service, creating it on first use.
+// The client is shared and safe for concurrent use; it is closed by Close.
+func Client(service string) (client.XClient, error) {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	return getClient(service, clientConfig(service))
+}
 
-	basePath := kcfg.Get[string]("base")
-	if basePath == "" {
-		basePath = "gokit"
+// getClient returns the cached client of service built with cfg. The caller
+// must hold clientsMu.
+func getClient(service string, cfg ClientConfig) (client.XClient, error) {
+	key := cfg.key(service)
+	if xclient, found := clients[key]; found {
+		return xclient, nil
 	}
 
-	registry := kcfg.Get[string]("registry")
-	if registry == "" {
-		registry = "etcd"
+	registry, err := getRegistry()
+	if err != nil {
+		return nil, err
 	}
 
-	etcdAddrs := kvar.New(kcfg.Get[[]any]("rpc.etcd.addrs")).Strings()
-	if etcdAddrs == nil || len(etcdAddrs) == 0 {
-		klog.Fatal("Missing etcd addrs")
+	d, err := registry.Discovery(service)
+	if err != nil {
+		return nil, err
+	}
+	// rpcx panics dialing kcp without a Block and skips verifying quic
+	// without a TLSConfig
+	for _, pair := range d.GetServices() {
+		network := strings.SplitN(pair.Key, "@", 2)[0]
+		if network == "quic" && cfg.TLSConfig == nil {
+			return nil, fmt.Errorf("rpc service %s is served over quic, its client needs a TLSConfig", service)
+		}
+		if network == "kcp" && cfg.Block == nil {
+			return nil, fmt.Errorf("rpc service %s is served over kcp, its client needs a Block", service)
+		}
 	}
 
 	option := client.DefaultOption
-	option.SerializeType = rpcx_protocol.JSON
-	d, _ := etcd_client.NewEtcdV3Discovery(basePath, service, etcdAddrs, false, nil)
-	xclient := client.NewXClient(service, client.Failover, client.RoundRobin, d, option)
-	defer xclient.Close()
+	option.TLSConfig = cfg.TLSConfig
+	option.Block = cfg.Block
+	option.SerializeType = cfg.SerializeType
+	option.Retries = cfg.Retries
+	if cfg.ConnectTimeout > 0 {
+		option.ConnectTimeout = cfg.ConnectTimeout
+	}
+
+	failMode := client.Failover
+	if mode, found := rpcxFailModes[cfg.FailMode]; found {
+		failMode = mode
+	}
+
+	xclient := client.NewXClient(service, failMode, cfg.SelectMode, d, option)
+	if cfg.SelectMode == ConsistentHash {
+		xclient.SetSelector(newHashSelector(d.GetServices()))
+	}
+	xclient.GetPlugins().Add(&hashKeyPlugin{discovery: d})
+	clients[key] = xclient
+
+	closeOnce.Do(func() {
+		kitServer.OnShutdown(func(ctx context.Context) error {
+			return Close()
+		})
+	})
+	return xclient, nil
+}
+
+// Close closes every cached client.
+func Close() error {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+
+	var err error
+	for key, xclient := range clients {
+		if closeErr := xclient.Close(); closeErr != nil {
+			klog.Args("client", key, "err", closeErr.Error()).Warn("Close rpc client failed")
+			err = closeErr
+		}
+		delete(clients, key)
+	}
+	return err
+}
+
+func Call(service, method string, req, rsp any) error {
+	return CallContext(context.Background(), service, method, req, rsp)
+}
+
+// CallContext calls service.method with the service's client config
+// overridden by opts. Metadata attached to ctx by WithMetadata or
+// WithCallMetadata is sent along with the request.
+func CallContext(ctx context.Context, service, method string, req, rsp any, opts ...CallOption) error {
+	clientsMu.Lock()
+	options := callOptions{ClientConfig: clientConfig(service)}
+	clientsMu.Unlock()
+
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	clientsMu.Lock()
+	xclient, err := getClient(service, options.ClientConfig)
+	clientsMu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if _, found := ctx.Deadline(); !found && options.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, options.Timeout)
+		defer cancel()
+	}
+
+	ctx = outgoingContext(ctx, options.metadata)
+	if options.hashKey != "" {
+		ctx = context.WithValue(ctx, hashKeyCtxKey{}, options.hashKey)
+	}
+
+	invoke := func(ctx context.Context, req, rsp any) (err error) {
+		resMeta := map[string]string{}
+		ctx = context.WithValue(ctx, share.ResMetaDataKey, resMeta)
+
+		switch options.FailMode {
+		case Broadcast:
+			err = xclient.Broadcast(ctx, method, req, rsp)
+		case Forking:
+			err = xclient.Fork(ctx, method, req, rsp)
+		default:
+			err = xclient.Call(ctx, method, req, rsp)
+		}
+
+		// rebuild the coded error the handler returned
+		if err != nil && resMeta[kerrors.MetadataKey] != "" {
+			if e, decodeErr := kerrors.Decode(resMeta[kerrors.MetadataKey]); decodeErr == nil {
+				return e
+			}
+		}
+		return err
+	}
+
+	info := &ClientInfo{
+		Service:    service,
+		Method:     method,
+		Idempotent: options.idempotent,
+	}
+	return chainClient(_clientInterceptors, info, invoke)(ctx, req, rsp)
+}
+
+// clientConfig resolves the config of service from ConfigureClient, then
+// rpc.clients.<service>, then rpc.client. The caller must hold clientsMu.
+func clientConfig(service string) ClientConfig {
+	if cfg, found := clientConfigs[service]; found {
+		return cfg
+	}
+
+	cfg := ClientConfig{
+		FailMode:      Failover,
+		SelectMode:    RoundRobin,
+		SerializeType: rpcx_protocol.JSON,
+		Retries:       client.DefaultOption.Retries,
+	}
+
+	for _, key := range []string{"rpc.client", fmt.Sprintf("rpc.clients.%s", service)} {
+		values := kvar.New(kcfg.Get[any](key)).MapVar()
+		for name, val := range values {
+			switch strings.ToLower(name) {
+			case "failmode":
+				if mode, found := failModes[strings.ToLower(val.String())]; found {
+					cfg.FailMode = mode
+				}
+			case "selectmode", "selector":
+				if mode, found := selectModes[strings.ToLower(val.String())]; found {
+					cfg.SelectMode = mode
+				}
+			case "serialize", "serializetype":
+				if typ, found := serializeTypes[strings.ToLower(val.String())]; found {
+					cfg.SerializeType = typ
+				}
+			case "retries":
+				cfg.Retries = val.Int()
+			case "connecttimeout":
+				cfg.ConnectTimeout = val.Duration()
+			case "timeout":
+				cfg.Timeout = val.Duration()
+			}
+		}
+	}
+
+	clientConfigs[service] = cfg
+	return cfg
+}
 
-	return xclient.Call(context.Background(), method, req, rsp)
+var serializeTypes = map[string]rpcx_protocol.SerializeType{
+	"json":     rpcx_protocol.JSON,
+	"msgpack":  rpcx_protocol.MsgPack,
+	"protobuf": rpcx_protocol.ProtoBuffer,
+	"thrift":   rpcx_protocol.Thrift,
 }
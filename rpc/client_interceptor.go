@@ -0,0 +1,287 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/rcrowley/go-metrics"
+	"github.com/smallnest/rpcx/client"
+	"github.com/xinzf/kit/kerrors"
+	"github.com/xinzf/kit/klog"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// ClientInfo describes the call a ClientInterceptor wraps.
+type ClientInfo struct {
+	Service string
+	Method  string
+	// Idempotent is set by WithIdempotent and tells Retry the call is safe
+	// to send more than once.
+	Idempotent bool
+}
+
+// Invoker runs the rest of the chain and finally sends the call.
+type Invoker func(ctx context.Context, req, rsp any) error
+
+// ClientInterceptor wraps every call made through CallContext.
+type ClientInterceptor func(ctx context.Context, info *ClientInfo, req, rsp any, next Invoker) error
+
+var _clientInterceptors []ClientInterceptor
+
+// UseClient appends interceptors to the client chain. The first one is the
+// outermost. UseClient must be called before the first call.
+func UseClient(interceptors ...ClientInterceptor) {
+	_clientInterceptors = append(_clientInterceptors, interceptors...)
+}
+
+func chainClient(interceptors []ClientInterceptor, info *ClientInfo, final Invoker) Invoker {
+	next := final
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, _next := interceptors[i], next
+		next = func(ctx context.Context, req, rsp any) error {
+			return interceptor(ctx, info, req, rsp, _next)
+		}
+	}
+	return next
+}
+
+// isServiceError reports whether err was returned by the handler, as opposed
+// to a transport failure. Handler errors reached a healthy node, so they
+// never trip breakers and are only retried when marked retryable.
+func isServiceError(err error) bool {
+	if _, ok := kerrors.FromError(err); ok {
+		return true
+	}
+	var serviceErr client.ServiceError
+	return errors.As(err, &serviceErr)
+}
+
+// RetryPolicy configures Retry.
+type RetryPolicy struct {
+	// MaxAttempts counts the first call too.
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	// Methods lists service.method names that are idempotent in addition
+	// to calls made with WithIdempotent.
+	Methods []string
+}
+
+// Retry resends idempotent calls that failed in transport or with a
+// retryable kerrors.Error, sleeping an exponentially growing, jittered
+// backoff between attempts.
+func Retry(policy RetryPolicy) ClientInterceptor {
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = 3
+	}
+	if policy.InitialBackoff <= 0 {
+		policy.InitialBackoff = 100 * time.Millisecond
+	}
+	if policy.MaxBackoff <= 0 {
+		policy.MaxBackoff = 5 * time.Second
+	}
+	if policy.Multiplier < 1 {
+		policy.Multiplier = 2
+	}
+	idempotent := make(map[string]bool)
+	for _, method := range policy.Methods {
+		idempotent[method] = true
+	}
+
+	return func(ctx context.Context, info *ClientInfo, req, rsp any, next Invoker) error {
+		if !info.Idempotent && !idempotent[info.Service+"."+info.Method] {
+			return next(ctx, req, rsp)
+		}
+
+		backoff := policy.InitialBackoff
+		var err error
+		for attempt := 1; ; attempt++ {
+			err = next(ctx, req, rsp)
+			if err == nil || attempt >= policy.MaxAttempts {
+				return err
+			}
+			if isServiceError(err) && !kerrors.IsRetryable(err) {
+				return err
+			}
+
+			sleep := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+			klog.Args(
+				"service", info.Service,
+				"method", info.Method,
+				"attempt", attempt,
+				"backoff", sleep.String(),
+				"err", err.Error(),
+			).Warn("Rpc call retry")
+
+			select {
+			case <-ctx.Done():
+				return err
+			case <-time.After(sleep):
+			}
+
+			backoff = time.Duration(float64(backoff) * policy.Multiplier)
+			if backoff > policy.MaxBackoff {
+				backoff = policy.MaxBackoff
+			}
+		}
+	}
+}
+
+// BreakerState is the state of a circuit breaker.
+type BreakerState int
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// ErrBreakerOpen is returned for calls rejected by an open circuit breaker.
+var ErrBreakerOpen = errors.New("rpc: circuit breaker is open")
+
+// BreakerConfig configures CircuitBreaker.
+type BreakerConfig struct {
+	// Failures is the number of consecutive transport failures that opens
+	// the breaker of a service.
+	Failures int
+	// OpenTimeout is how long an open breaker rejects calls before letting
+	// a single probe through.
+	OpenTimeout time.Duration
+	// Registry receives a rpc.breaker.<service> gauge holding the state,
+	// nil means metrics.DefaultRegistry.
+	Registry metrics.Registry
+}
+
+type breaker struct {
+	mu       sync.Mutex
+	service  string
+	cfg      BreakerConfig
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func (this *breaker) allow() bool {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	switch this.state {
+	case BreakerOpen:
+		if time.Since(this.openedAt) < this.cfg.OpenTimeout {
+			return false
+		}
+		this.setState(BreakerHalfOpen)
+		this.probing = true
+		return true
+	case BreakerHalfOpen:
+		if this.probing {
+			return false
+		}
+		this.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+// done records the result of an allowed call. Calls canceled by their
+// caller say nothing about the service, so they neither fail nor close the
+// breaker: a canceled probe leaves it half-open for the next one.
+func (this *breaker) done(err error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	this.probing = false
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	if err == nil || isServiceError(err) {
+		this.failures = 0
+		if this.state != BreakerClosed {
+			this.setState(BreakerClosed)
+		}
+		return
+	}
+
+	this.failures++
+	if this.state == BreakerHalfOpen || this.failures >= this.cfg.Failures {
+		this.openedAt = time.Now()
+		if this.state != BreakerOpen {
+			this.setState(BreakerOpen)
+		}
+	}
+}
+
+func (this *breaker) setState(state BreakerState) {
+	klog.Args("service", this.service, "from", this.state.String(), "to", state.String()).Warn("Rpc circuit breaker changed")
+	this.state = state
+	metrics.GetOrRegisterGauge(fmt.Sprintf("rpc.breaker.%s", this.service), this.cfg.Registry).Update(int64(state))
+}
+
+// CircuitBreaker keeps one breaker per service. An open breaker fails calls
+// with ErrBreakerOpen without sending them.
+func CircuitBreaker(cfg BreakerConfig) ClientInterceptor {
+	if cfg.Failures < 1 {
+		cfg.Failures = 5
+	}
+	if cfg.OpenTimeout <= 0 {
+		cfg.OpenTimeout = 10 * time.Second
+	}
+	if cfg.Registry == nil {
+		cfg.Registry = metrics.DefaultRegistry
+	}
+
+	var breakers sync.Map
+	return func(ctx context.Context, info *ClientInfo, req, rsp any, next Invoker) error {
+		val, _ := breakers.LoadOrStore(info.Service, &breaker{service: info.Service, cfg: cfg})
+		b := val.(*breaker)
+		if !b.allow() {
+			return ErrBreakerOpen
+		}
+
+		err := next(ctx, req, rsp)
+		b.done(err)
+		return err
+	}
+}
+
+// ConcurrencyLimit allows at most limit calls in flight per service. Calls
+// over the limit wait for a slot until their context is done. A limit of 0
+// or less does not limit calls.
+func ConcurrencyLimit(limit int) ClientInterceptor {
+	if limit <= 0 {
+		return func(ctx context.Context, info *ClientInfo, req, rsp any, next Invoker) error {
+			return next(ctx, req, rsp)
+		}
+	}
+	var slots sync.Map
+	return func(ctx context.Context, info *ClientInfo, req, rsp any, next Invoker) error {
+		val, _ := slots.LoadOrStore(info.Service, make(chan struct{}, limit))
+		sem := val.(chan struct{})
+
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		defer func() {
+			<-sem
+		}()
+		return next(ctx, req, rsp)
+	}
+}
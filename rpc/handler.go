@@ -1,9 +1,17 @@
 package rpc
 
 import (
+	"context"
+	"errors"
+	"github.com/smallnest/rpcx/server"
+	"github.com/smallnest/rpcx/share"
+	"github.com/xinzf/kit/kerrors"
 	"github.com/xinzf/kit/klog"
+	"github.com/xinzf/kit/ktrace"
 	kitServer "github.com/xinzf/kit/server"
 	"reflect"
+	"strconv"
+	"time"
 )
 
 func newHandler(hand any) *handler {
@@ -29,38 +37,13 @@ func newHandler(hand any) *handler {
 
 	for i := 0; i < refValue.NumMethod(); i++ {
 		methodName := refType.Method(i).Name
-		method := refValue.Method(i)
-
-		if method.Type().NumIn() != 3 || method.Type().NumOut() != 1 {
-			continue
-		}
-
-		var (
-			c      = method.Type().In(0)
-			req    = method.Type().In(1)
-			rsp    = method.Type().In(2)
-			output = method.Type().Out(0)
-		)
-
-		if c.String() != "context.Context" {
-			klog.Warnf("the context argument of %s.%s is not context.Context", handlerName, methodName)
-			continue
-		}
-
-		if req.Kind() != reflect.Ptr && req.Kind() != reflect.Interface {
-			klog.Warnf("the request argument of %s.%s is not a pointer", handlerName, methodName)
-			continue
-		}
-
-		if rsp.Kind() != reflect.Interface && rsp.Kind() != reflect.Pointer {
-			klog.Warnf("the response argument of %s.%s is not a pointer", handlerName, methodName)
+		if err := checkMethod(refValue.Method(i).Type()); err != nil {
+			if err != errNotHandler {
+				klog.Warnf("%s.%s is skipped: %s", handlerName, methodName, err.Error())
+			}
 			continue
 		}
 
-		if output.String() != "error" {
-			klog.Warnf("the output error of %s.%s is not error type", handlerName, methodName)
-		}
-
 		methods = append(methods, methodName)
 	}
 
@@ -84,3 +67,104 @@ type handler struct {
 	serviceName string
 	methods     []string
 }
+
+// dispatch returns the rpcx handler serving method through the server
+// interceptor chain.
+func (this *handler) dispatch(servicePath, method string, interceptors []ServerInterceptor) func(sctx *server.Context) error {
+	fn := reflect.ValueOf(this.hdl).MethodByName(method)
+	reqType, rspType := fn.Type().In(1), fn.Type().In(2)
+
+	final := func(ctx context.Context, req, rsp any) error {
+		values := fn.Call([]reflect.Value{
+			reflect.ValueOf(ctx),
+			reflect.ValueOf(req),
+			reflect.ValueOf(rsp),
+		})
+		err, _ := values[0].Interface().(error)
+		return err
+	}
+
+	return func(sctx *server.Context) error {
+		req := newArgument(reqType)
+		if err := sctx.Bind(req); err != nil {
+			return sctx.WriteError(err)
+		}
+		rsp := newArgument(rspType)
+
+		ctx, cancel := serverContext(sctx)
+		defer cancel()
+
+		info := &ServerInfo{
+			Service:  servicePath,
+			Method:   method,
+			Metadata: sctx.Metadata(),
+		}
+		if err := chainServer(interceptors, info, final)(ctx, req, rsp); err != nil {
+			if e, ok := kerrors.FromError(err); ok {
+				if resMeta, ok := sctx.Get(share.ResMetaDataKey).(map[string]string); ok {
+					resMeta[kerrors.MetadataKey] = e.Encode()
+				}
+			}
+			return sctx.WriteError(err)
+		}
+		return sctx.Write(rsp)
+	}
+}
+
+func newArgument(typ reflect.Type) any {
+	if typ.Kind() == reflect.Ptr {
+		return reflect.New(typ.Elem()).Interface()
+	}
+	return reflect.New(typ).Interface()
+}
+
+// serverContext rebuilds the context rpcx hands to registered services:
+// the connection, both metadata maps and the deadline sent by the client. It
+// also continues the trace the client sent, or starts one.
+func serverContext(sctx *server.Context) (context.Context, context.CancelFunc) {
+	ctx := share.NewContext(context.Background())
+	ctx.SetValue(server.RemoteConnContextKey, sctx.Get(server.RemoteConnContextKey))
+	ctx.SetValue(share.ReqMetaDataKey, sctx.Metadata())
+	ctx.SetValue(share.ResMetaDataKey, sctx.Get(share.ResMetaDataKey))
+
+	md := sctx.Metadata()
+	traced := ktrace.NewContext(ctx, ktrace.Start(md[ktrace.RequestIdHeader], md[ktrace.TraceparentHeader]))
+	if ms, err := strconv.ParseInt(sctx.Metadata()[share.ServerTimeout], 10, 64); err == nil && ms > 0 {
+		return context.WithTimeout(traced, time.Duration(ms)*time.Millisecond)
+	}
+	return context.WithCancel(traced)
+}
+
+var errNotHandler = errors.New("not a handler method")
+
+// checkMethod reports whether a method, without its receiver, has the
+// func(context.Context, *Req, *Rsp) error shape rpc handlers must have.
+func checkMethod(method reflect.Type) error {
+	if method.NumIn() != 3 || method.NumOut() != 1 {
+		return errNotHandler
+	}
+
+	var (
+		c      = method.In(0)
+		req    = method.In(1)
+		rsp    = method.In(2)
+		output = method.Out(0)
+	)
+
+	if c.String() != "context.Context" {
+		return errors.New("context argument is not context.Context")
+	}
+
+	if req.Kind() != reflect.Ptr && req.Kind() != reflect.Interface {
+		return errors.New("request argument is not a pointer")
+	}
+
+	if rsp.Kind() != reflect.Interface && rsp.Kind() != reflect.Pointer {
+		return errors.New("response argument is not a pointer")
+	}
+
+	if output.String() != "error" {
+		return errors.New("output error is not error type")
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/rcrowley/go-metrics"
+	"github.com/smallnest/rpcx/share"
+	"github.com/xinzf/kit/klog"
+	"runtime/debug"
+	"time"
+)
+
+// ServerInfo describes the call a ServerInterceptor wraps.
+type ServerInfo struct {
+	Service  string
+	Method   string
+	Metadata map[string]string
+}
+
+// ServerHandler runs the rest of the chain and finally the handler method.
+type ServerHandler func(ctx context.Context, req, rsp any) error
+
+// ServerInterceptor wraps every call dispatched to the handlers registered
+// by Register. It must call next to continue the call.
+type ServerInterceptor func(ctx context.Context, info *ServerInfo, req, rsp any, next ServerHandler) error
+
+var _interceptors []ServerInterceptor
+
+// Use appends interceptors to the server chain. The first one is the
+// outermost. Use must be called before Run.
+func Use(interceptors ...ServerInterceptor) {
+	_interceptors = append(_interceptors, interceptors...)
+}
+
+func chainServer(interceptors []ServerInterceptor, info *ServerInfo, final ServerHandler) ServerHandler {
+	next := final
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, _next := interceptors[i], next
+		next = func(ctx context.Context, req, rsp any) error {
+			return interceptor(ctx, info, req, rsp, _next)
+		}
+	}
+	return next
+}
+
+// Recovery turns a panicking handler into an error response and logs the
+// stack through klog.
+func Recovery() ServerInterceptor {
+	return func(ctx context.Context, info *ServerInfo, req, rsp any, next ServerHandler) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				klog.WithContext(ctx).Args(
+					"service", info.Service,
+					"method", info.Method,
+					"panic", fmt.Sprintf("%v", r),
+					"stack", string(debug.Stack()),
+				).Error("Rpc handler panic")
+				err = fmt.Errorf("rpc handler panic: %v", r)
+			}
+		}()
+		return next(ctx, req, rsp)
+	}
+}
+
+// AccessLog logs every call with its latency through klog.
+func AccessLog() ServerInterceptor {
+	return func(ctx context.Context, info *ServerInfo, req, rsp any, next ServerHandler) error {
+		start := time.Now()
+		err := next(ctx, req, rsp)
+
+		args := []any{
+			"service", info.Service,
+			"method", info.Method,
+			"latency", time.Since(start).String(),
+		}
+		if err != nil {
+			klog.WithContext(ctx).Args(append(args, "err", err.Error())...).Warn("Rpc call failed")
+		} else {
+			klog.WithContext(ctx).Args(args...).Info("Rpc call")
+		}
+		return err
+	}
+}
+
+// Metrics records a latency timer and an error meter per method, named
+// rpc.<service>.<method> and rpc.<service>.<method>.errors. A nil registry
+// means metrics.DefaultRegistry.
+func Metrics(registry metrics.Registry) ServerInterceptor {
+	if registry == nil {
+		registry = metrics.DefaultRegistry
+	}
+	return func(ctx context.Context, info *ServerInfo, req, rsp any, next ServerHandler) error {
+		name := fmt.Sprintf("rpc.%s.%s", info.Service, info.Method)
+		start := time.Now()
+		err := next(ctx, req, rsp)
+		metrics.GetOrRegisterTimer(name, registry).UpdateSince(start)
+		if err != nil {
+			metrics.GetOrRegisterMeter(name+".errors", registry).Mark(1)
+		}
+		return err
+	}
+}
+
+// ErrUnauthorized is returned by TokenAuth when the caller sent no token.
+var ErrUnauthorized = errors.New("rpc: unauthorized")
+
+// TokenAuth rejects calls whose token, sent with WithToken, is refused by
+// verify.
+func TokenAuth(verify func(ctx context.Context, token string, info *ServerInfo) error) ServerInterceptor {
+	return func(ctx context.Context, info *ServerInfo, req, rsp any, next ServerHandler) error {
+		token := info.Metadata[share.AuthKey]
+		if token == "" {
+			return ErrUnauthorized
+		}
+		if err := verify(ctx, token, info); err != nil {
+			return err
+		}
+		return next(ctx, req, rsp)
+	}
+}
@@ -0,0 +1,57 @@
+package rpc
+
+import (
+	"context"
+	"github.com/smallnest/rpcx/share"
+	"github.com/xinzf/kit/ktrace"
+)
+
+type outgoingCtxKey struct{}
+
+// WithMetadata returns a copy of ctx carrying md, which is sent with every
+// call made through ctx. Later values override earlier ones.
+func WithMetadata(ctx context.Context, md map[string]string) context.Context {
+	merged := map[string]string{}
+	if parent, ok := ctx.Value(outgoingCtxKey{}).(map[string]string); ok {
+		for k, v := range parent {
+			merged[k] = v
+		}
+	}
+	for k, v := range md {
+		merged[k] = v
+	}
+	return context.WithValue(ctx, outgoingCtxKey{}, merged)
+}
+
+// Metadata returns the metadata the caller sent with the request being
+// handled. It returns nil outside of an rpc handler.
+func Metadata(ctx context.Context) map[string]string {
+	md, _ := ctx.Value(share.ReqMetaDataKey).(map[string]string)
+	return md
+}
+
+// MetadataValue returns a single value of Metadata.
+func MetadataValue(ctx context.Context, key string) string {
+	return Metadata(ctx)[key]
+}
+
+// outgoingContext puts the metadata to send where rpcx reads it, starting
+// with the trace of ctx. It always installs a fresh map, so the metadata of
+// the request being handled is never forwarded by accident.
+func outgoingContext(ctx context.Context, extra map[string]string) context.Context {
+	md := map[string]string{}
+	if t, ok := ktrace.FromContext(ctx); ok {
+		for k, v := range t.Metadata() {
+			md[k] = v
+		}
+	}
+	if parent, ok := ctx.Value(outgoingCtxKey{}).(map[string]string); ok {
+		for k, v := range parent {
+			md[k] = v
+		}
+	}
+	for k, v := range extra {
+		md[k] = v
+	}
+	return context.WithValue(ctx, share.ReqMetaDataKey, md)
+}
@@ -0,0 +1,133 @@
+package rpc
+
+import (
+	"github.com/smallnest/rpcx/client"
+	rpcx_protocol "github.com/smallnest/rpcx/protocol"
+	"github.com/smallnest/rpcx/share"
+	"time"
+)
+
+// FailMode decides what a call does when the selected node fails.
+// Broadcast and Forking send the call to every node: Broadcast succeeds
+// when all of them do, Forking as soon as one of them does.
+type FailMode int
+
+const (
+	Failover FailMode = iota
+	Failfast
+	Failtry
+	Failbackup
+	Broadcast
+	Forking
+)
+
+var failModes = map[string]FailMode{
+	"failover":   Failover,
+	"failfast":   Failfast,
+	"failtry":    Failtry,
+	"failbackup": Failbackup,
+	"broadcast":  Broadcast,
+	"forking":    Forking,
+}
+
+var rpcxFailModes = map[FailMode]client.FailMode{
+	Failover:   client.Failover,
+	Failfast:   client.Failfast,
+	Failtry:    client.Failtry,
+	Failbackup: client.Failbackup,
+}
+
+// SelectMode decides which node serves a call.
+type SelectMode = client.SelectMode
+
+const (
+	RoundRobin         = client.RoundRobin
+	Random             = client.RandomSelect
+	WeightedRoundRobin = client.WeightedRoundRobin
+	ConsistentHash     = client.ConsistentHash
+	Closest            = client.Closest
+)
+
+var selectModes = map[string]SelectMode{
+	"roundrobin":         RoundRobin,
+	"random":             Random,
+	"weightedroundrobin": WeightedRoundRobin,
+	"consistenthash":     ConsistentHash,
+	"closest":            Closest,
+}
+
+type callOptions struct {
+	ClientConfig
+	hashKey    string
+	metadata   map[string]string
+	idempotent bool
+}
+
+// CallOption overrides the client config of a single call.
+type CallOption func(opts *callOptions)
+
+// WithTimeout bounds the call when ctx has no deadline of its own.
+func WithTimeout(timeout time.Duration) CallOption {
+	return func(opts *callOptions) {
+		opts.Timeout = timeout
+	}
+}
+
+// WithRetries sets how often Failover and Failtry retry the call.
+func WithRetries(retries int) CallOption {
+	return func(opts *callOptions) {
+		opts.Retries = retries
+	}
+}
+
+func WithFailMode(mode FailMode) CallOption {
+	return func(opts *callOptions) {
+		opts.FailMode = mode
+	}
+}
+
+func WithSelectMode(mode SelectMode) CallOption {
+	return func(opts *callOptions) {
+		opts.SelectMode = mode
+	}
+}
+
+// WithHashKey routes the call by consistent hash on key, whatever the select
+// mode of the client, so calls sharing key land on the same node while the
+// node set is stable.
+func WithHashKey(key string) CallOption {
+	return func(opts *callOptions) {
+		opts.hashKey = key
+	}
+}
+
+func WithSerializeType(typ rpcx_protocol.SerializeType) CallOption {
+	return func(opts *callOptions) {
+		opts.SerializeType = typ
+	}
+}
+
+// WithCallMetadata adds md to the metadata sent with the call.
+func WithCallMetadata(md map[string]string) CallOption {
+	return func(opts *callOptions) {
+		if opts.metadata == nil {
+			opts.metadata = map[string]string{}
+		}
+		for k, v := range md {
+			opts.metadata[k] = v
+		}
+	}
+}
+
+// WithIdempotent marks the call as safe to send more than once, which lets
+// Retry resend it.
+func WithIdempotent() CallOption {
+	return func(opts *callOptions) {
+		opts.idempotent = true
+	}
+}
+
+// WithToken sends token for TokenAuth to check.
+func WithToken(token string) CallOption {
+	return WithCallMetadata(map[string]string{share.AuthKey: token})
+}
@@ -0,0 +1,315 @@
+package rpc
+
+import (
+	"errors"
+	"fmt"
+	"github.com/rcrowley/go-metrics"
+	etcd_client "github.com/rpcxio/rpcx-etcd/client"
+	"github.com/rpcxio/rpcx-etcd/serverplugin"
+	"github.com/smallnest/rpcx/client"
+	"github.com/smallnest/rpcx/server"
+	"github.com/xinzf/kit/container/kcfg"
+	"github.com/xinzf/kit/container/kvar"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Registry publishes rpc servers and resolves them for clients.
+type Registry interface {
+	// Register makes the services registered on serv discoverable under
+	// addr (network@host:port). The returned func removes them again.
+	Register(serv *server.Server, addr string) (deregister func() error, err error)
+	// Discovery resolves the nodes serving servicePath.
+	Discovery(servicePath string) (client.ServiceDiscovery, error)
+}
+
+var (
+	registryMu sync.Mutex
+	_registry  Registry
+	_memory    = NewMemoryRegistry()
+)
+
+// SetRegistry replaces the registry configured through the registry key.
+func SetRegistry(r Registry) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	_registry = r
+}
+
+func getRegistry() (Registry, error) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _registry != nil {
+		return _registry, nil
+	}
+
+	basePath := kcfg.Get[string]("base")
+	if basePath == "" {
+		basePath = "gokit"
+	}
+
+	registry := kcfg.Get[string]("registry")
+	if registry == "" {
+		registry = "etcd"
+	}
+
+	switch registry {
+	case "etcd":
+		etcdAddrs := kvar.New(kcfg.Get[[]any]("rpc.etcd.addrs")).Strings()
+		if len(etcdAddrs) == 0 {
+			return nil, errors.New("missing etcd addrs")
+		}
+		_registry = NewEtcdRegistry(basePath, etcdAddrs)
+	case "static":
+		peers := peersFromConfig()
+		if len(peers) == 0 {
+			return nil, errors.New("missing rpc peers")
+		}
+		_registry = NewStaticRegistry(peers)
+	case "memory":
+		_registry = _memory
+	default:
+		return nil, fmt.Errorf("unsupported registry: %s", registry)
+	}
+	return _registry, nil
+}
+
+// peersFromConfig reads rpc.peers, which is either a list of addresses shared
+// by every service or a map from rpc service name to its addresses.
+func peersFromConfig() map[string][]string {
+	peers := make(map[string][]string)
+	switch val := kcfg.Get[any]("rpc.peers").(type) {
+	case []any:
+		peers["*"] = kvar.New(val).Strings()
+	case map[string]any:
+		for name, addrs := range val {
+			peers[name] = kvar.New(addrs).Strings()
+		}
+	}
+	return peers
+}
+
+func kvPairs(addrs []string) []*client.KVPair {
+	pairs := make([]*client.KVPair, 0, len(addrs))
+	for _, addr := range addrs {
+		if !strings.Contains(addr, "@") {
+			addr = "tcp@" + addr
+		}
+		pairs = append(pairs, &client.KVPair{Key: addr})
+	}
+	return pairs
+}
+
+type etcdRegistry struct {
+	basePath string
+	addrs    []string
+}
+
+// NewEtcdRegistry returns a Registry storing nodes in etcd under basePath.
+func NewEtcdRegistry(basePath string, addrs []string) Registry {
+	return &etcdRegistry{basePath: basePath, addrs: addrs}
+}
+
+func (this *etcdRegistry) Register(serv *server.Server, addr string) (func() error, error) {
+	reg := &serverplugin.EtcdV3RegisterPlugin{
+		ServiceAddress: addr,
+		EtcdServers:    this.addrs,
+		BasePath:       this.basePath,
+		Metrics:        metrics.NewRegistry(),
+		UpdateInterval: time.Second * 30,
+	}
+	if err := reg.Start(); err != nil {
+		return nil, err
+	}
+
+	serv.Plugins.Add(reg)
+	return reg.Stop, nil
+}
+
+func (this *etcdRegistry) Discovery(servicePath string) (client.ServiceDiscovery, error) {
+	return etcd_client.NewEtcdV3Discovery(this.basePath, servicePath, this.addrs, false, nil)
+}
+
+type staticRegistry struct {
+	peers map[string][]string
+}
+
+// NewStaticRegistry returns a Registry resolving services from a fixed peer
+// list. Peers are looked up by the full service path, then by the rpc service
+// name before the first dot, then by "*".
+func NewStaticRegistry(peers map[string][]string) Registry {
+	return &staticRegistry{peers: peers}
+}
+
+func (this *staticRegistry) Register(_ *server.Server, _ string) (func() error, error) {
+	return func() error { return nil }, nil
+}
+
+func (this *staticRegistry) Discovery(servicePath string) (client.ServiceDiscovery, error) {
+	name := strings.ToLower(strings.SplitN(servicePath, ".", 2)[0])
+	for _, key := range []string{servicePath, name, "*"} {
+		if addrs, found := this.peers[key]; found && len(addrs) > 0 {
+			return client.NewMultipleServersDiscovery(kvPairs(addrs))
+		}
+	}
+	return nil, fmt.Errorf("no peers configured for service: %s", servicePath)
+}
+
+// MemoryRegistry keeps nodes in process memory. Servers and clients sharing
+// one instance find each other without any external registry, which suits
+// tests and single binary deployments.
+type MemoryRegistry struct {
+	mu          sync.Mutex
+	nodes       map[string][]string
+	discoveries map[string]*memoryDiscovery
+}
+
+// NewMemoryRegistry returns an empty MemoryRegistry.
+func NewMemoryRegistry() *MemoryRegistry {
+	return &MemoryRegistry{
+		nodes:       map[string][]string{},
+		discoveries: map[string]*memoryDiscovery{},
+	}
+}
+
+func (this *MemoryRegistry) Register(serv *server.Server, addr string) (func() error, error) {
+	plugin := &memoryPlugin{registry: this, addr: addr}
+	serv.Plugins.Add(plugin)
+	return plugin.stop, nil
+}
+
+func (this *MemoryRegistry) Discovery(servicePath string) (client.ServiceDiscovery, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return this.discovery(servicePath), nil
+}
+
+func (this *MemoryRegistry) discovery(servicePath string) *memoryDiscovery {
+	d, found := this.discoveries[servicePath]
+	if !found {
+		d = &memoryDiscovery{pairs: kvPairs(this.nodes[servicePath])}
+		this.discoveries[servicePath] = d
+	}
+	return d
+}
+
+func (this *MemoryRegistry) add(servicePath, addr string) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	for _, node := range this.nodes[servicePath] {
+		if node == addr {
+			return
+		}
+	}
+	this.nodes[servicePath] = append(this.nodes[servicePath], addr)
+	this.discovery(servicePath).update(kvPairs(this.nodes[servicePath]))
+}
+
+func (this *MemoryRegistry) remove(servicePath, addr string) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	nodes := make([]string, 0)
+	for _, node := range this.nodes[servicePath] {
+		if node != addr {
+			nodes = append(nodes, node)
+		}
+	}
+	this.nodes[servicePath] = nodes
+	this.discovery(servicePath).update(kvPairs(nodes))
+}
+
+// memoryDiscovery resolves a service of a MemoryRegistry. Unlike
+// client.MultipleServersDiscovery it notifies the watchers under its lock,
+// so a closing client never closes a watcher an update is sent to.
+type memoryDiscovery struct {
+	mu       sync.Mutex
+	pairs    []*client.KVPair
+	watchers []chan []*client.KVPair
+}
+
+func (this *memoryDiscovery) GetServices() []*client.KVPair {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	return this.pairs
+}
+
+func (this *memoryDiscovery) WatchService() chan []*client.KVPair {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	ch := make(chan []*client.KVPair, 10)
+	this.watchers = append(this.watchers, ch)
+	return ch
+}
+
+func (this *memoryDiscovery) RemoveWatcher(ch chan []*client.KVPair) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	watchers := make([]chan []*client.KVPair, 0, len(this.watchers))
+	for _, watcher := range this.watchers {
+		if watcher != ch {
+			watchers = append(watchers, watcher)
+		}
+	}
+	this.watchers = watchers
+}
+
+func (this *memoryDiscovery) Clone(_ string) (client.ServiceDiscovery, error) {
+	return this, nil
+}
+
+func (this *memoryDiscovery) SetFilter(_ client.ServiceDiscoveryFilter) {}
+
+func (this *memoryDiscovery) Close() {}
+
+// update replaces the nodes and sends them to every watcher. A watcher
+// which has not read the previous nodes yet loses them rather than blocking
+// the registry, the new ones supersede them.
+func (this *memoryDiscovery) update(pairs []*client.KVPair) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.pairs = pairs
+	for _, ch := range this.watchers {
+		for sent := false; !sent; {
+			select {
+			case ch <- pairs:
+				sent = true
+			default:
+				select {
+				case <-ch:
+				default:
+				}
+			}
+		}
+	}
+}
+
+type memoryPlugin struct {
+	registry *MemoryRegistry
+	addr     string
+	mu       sync.Mutex
+	services []string
+}
+
+func (this *memoryPlugin) Register(name string, _ interface{}, _ string) error {
+	this.mu.Lock()
+	this.services = append(this.services, name)
+	this.mu.Unlock()
+	this.registry.add(name, this.addr)
+	return nil
+}
+
+func (this *memoryPlugin) Unregister(name string) error {
+	this.registry.remove(name, this.addr)
+	return nil
+}
+
+func (this *memoryPlugin) stop() error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	for _, name := range this.services {
+		this.registry.remove(name, this.addr)
+	}
+	this.services = nil
+	return nil
+}
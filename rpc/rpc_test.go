@@ -0,0 +1,512 @@
+package rpc
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"github.com/smallnest/rpcx/client"
+	"github.com/smallnest/rpcx/server"
+	"github.com/xinzf/kit/container/kcfg"
+	"github.com/xinzf/kit/kerrors"
+	"github.com/xinzf/kit/ktrace"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type EchoRequest struct {
+	Msg string `json:"msg"`
+}
+
+type EchoResponse struct {
+	Msg string `json:"msg"`
+}
+
+type Echo struct{}
+
+func (this *Echo) Say(ctx context.Context, req *EchoRequest, rsp *EchoResponse) error {
+	rsp.Msg = req.Msg
+	return nil
+}
+
+func (this *Echo) Tenant(ctx context.Context, req *EchoRequest, rsp *EchoResponse) error {
+	rsp.Msg = MetadataValue(ctx, "tenant")
+	return nil
+}
+
+func (this *Echo) Trace(ctx context.Context, req *EchoRequest, rsp *EchoResponse) error {
+	t, _ := ktrace.FromContext(ctx)
+	rsp.Msg = strings.Join([]string{t.RequestId, t.TraceId, t.ParentId}, " ")
+	return nil
+}
+
+func (this *Echo) Panic(ctx context.Context, req *EchoRequest, rsp *EchoResponse) error {
+	panic("boom")
+}
+
+func (this *Echo) Missing(ctx context.Context, req *EchoRequest, rsp *EchoResponse) error {
+	return kerrors.New(404, "echo not found").WithDetail("msg", req.Msg)
+}
+
+var intercepted int32
+
+func TestMain(m *testing.M) {
+	kcfg.Set("rpc.name", "test")
+	kcfg.Set("rpc.port", 18972)
+	kcfg.Set("registry", "memory")
+	// rpcx starts its http gateways in goroutines which race Shutdown, and
+	// the tests don't use them
+	ServerOptions(func(s *server.Server) {
+		s.DisableHTTPGateway = true
+		s.DisableJSONRPC = true
+	})
+	Register(&Echo{})
+	Use(Recovery(), func(ctx context.Context, info *ServerInfo, req, rsp any, next ServerHandler) error {
+		atomic.AddInt32(&intercepted, 1)
+		return next(ctx, req, rsp)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := Run(ctx); err != nil {
+			panic(err)
+		}
+	}()
+	time.Sleep(200 * time.Millisecond)
+
+	m.Run()
+	cancel()
+	<-done
+}
+
+func TestCall(t *testing.T) {
+	rsp := &EchoResponse{}
+	if err := Call("test.Echo", "Say", &EchoRequest{Msg: "hello"}, rsp); err != nil {
+		t.Fatal(err)
+	}
+	if rsp.Msg != "hello" {
+		t.Errorf("Call() = %s, want hello", rsp.Msg)
+	}
+}
+
+func TestClient(t *testing.T) {
+	c1, err := Client("test.Echo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, _ := Client("test.Echo")
+	if c1 != c2 {
+		t.Error("Client() should return the cached client")
+	}
+}
+
+func TestCallContext(t *testing.T) {
+	ctx := WithMetadata(context.Background(), map[string]string{"tenant": "t1"})
+	rsp := &EchoResponse{}
+	err := CallContext(ctx, "test.Echo", "Tenant", &EchoRequest{}, rsp, WithTimeout(time.Second), WithHashKey("t1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rsp.Msg != "t1" {
+		t.Errorf("CallContext() = %s, want t1", rsp.Msg)
+	}
+
+	clientsMu.Lock()
+	before := len(clients)
+	clientsMu.Unlock()
+	for _, key := range []string{"t2", "t3"} {
+		if err = CallContext(ctx, "test.Echo", "Tenant", &EchoRequest{}, rsp, WithHashKey(key), WithCallMetadata(map[string]string{"tenant": key})); err != nil {
+			t.Fatal(err)
+		}
+		if rsp.Msg != key {
+			t.Errorf("CallContext() = %s, want %s", rsp.Msg, key)
+		}
+	}
+	clientsMu.Lock()
+	after := len(clients)
+	clientsMu.Unlock()
+	if after != before {
+		t.Errorf("per-call options created %d clients", after-before)
+	}
+}
+
+type echoStub struct {
+	Say    func(ctx context.Context, req *EchoRequest, opts ...CallOption) (*EchoResponse, error)
+	Tenant func(ctx context.Context, req *EchoRequest) (*EchoResponse, error)
+}
+
+func TestInvoke(t *testing.T) {
+	rsp, err := Invoke[EchoRequest, EchoResponse](context.Background(), "test.Echo", "Say", &EchoRequest{Msg: "hi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rsp.Msg != "hi" {
+		t.Errorf("Invoke() = %s, want hi", rsp.Msg)
+	}
+}
+
+func TestNewStub(t *testing.T) {
+	stub, err := NewStub[echoStub]("test.Echo", (*Echo)(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsp, err := stub.Say(context.Background(), &EchoRequest{Msg: "stub"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rsp.Msg != "stub" {
+		t.Errorf("stub.Say() = %s, want stub", rsp.Msg)
+	}
+
+	type badStub struct {
+		Say func(ctx context.Context, req *EchoResponse) (*EchoResponse, error)
+	}
+	if _, err = NewStub[badStub]("test.Echo", (*Echo)(nil)); err == nil {
+		t.Error("NewStub() should reject a request type the handler does not take")
+	}
+
+	_, err = NewStub[echoStub]("test.Echo", (*noResultEcho)(nil))
+	if err == nil || !strings.Contains(err.Error(), "must return only an error") {
+		t.Errorf("NewStub() with a handler returning nothing = %v", err)
+	}
+}
+
+type noResultEcho struct{}
+
+func (this *noResultEcho) Say(ctx context.Context, req *EchoRequest, rsp *EchoResponse) {}
+
+func (this *noResultEcho) Tenant(ctx context.Context, req *EchoRequest, rsp *EchoResponse) {}
+
+func TestUse(t *testing.T) {
+	before := atomic.LoadInt32(&intercepted)
+	if err := Call("test.Echo", "Say", &EchoRequest{}, &EchoResponse{}); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(&intercepted) != before+1 {
+		t.Error("interceptor was not called")
+	}
+
+	err := CallContext(context.Background(), "test.Echo", "Panic", &EchoRequest{}, &EchoResponse{}, WithFailMode(Failfast))
+	if err == nil {
+		t.Error("Recovery() should turn the panic into an error")
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	cb := CircuitBreaker(BreakerConfig{Failures: 2, OpenTimeout: 50 * time.Millisecond})
+	info := &ClientInfo{Service: "test.Breaker", Method: "Do"}
+	failing := func(ctx context.Context, req, rsp any) error {
+		return errors.New("connection refused")
+	}
+	ok := func(ctx context.Context, req, rsp any) error {
+		return nil
+	}
+
+	for i := 0; i < 2; i++ {
+		_ = cb(context.Background(), info, nil, nil, failing)
+	}
+	if err := cb(context.Background(), info, nil, nil, ok); err != ErrBreakerOpen {
+		t.Fatalf("CircuitBreaker() = %v, want ErrBreakerOpen", err)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if err := cb(context.Background(), info, nil, nil, ok); err != nil {
+		t.Fatalf("half-open probe = %v, want nil", err)
+	}
+	if err := cb(context.Background(), info, nil, nil, ok); err != nil {
+		t.Errorf("closed breaker = %v, want nil", err)
+	}
+}
+
+func TestCircuitBreakerCanceledProbe(t *testing.T) {
+	cb := CircuitBreaker(BreakerConfig{Failures: 2, OpenTimeout: 50 * time.Millisecond})
+	info := &ClientInfo{Service: "test.BreakerCanceled", Method: "Do"}
+	failing := func(ctx context.Context, req, rsp any) error {
+		return errors.New("connection refused")
+	}
+	canceled := func(ctx context.Context, req, rsp any) error {
+		return context.Canceled
+	}
+	ok := func(ctx context.Context, req, rsp any) error {
+		return nil
+	}
+
+	for i := 0; i < 2; i++ {
+		_ = cb(context.Background(), info, nil, nil, failing)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if err := cb(context.Background(), info, nil, nil, canceled); !errors.Is(err, context.Canceled) {
+		t.Fatalf("canceled probe = %v", err)
+	}
+
+	// still half-open: the next call probes, and its single failure reopens
+	// the breaker
+	if err := cb(context.Background(), info, nil, nil, failing); err == nil || err == ErrBreakerOpen {
+		t.Fatalf("probe after a canceled one = %v, want its failure", err)
+	}
+	if err := cb(context.Background(), info, nil, nil, ok); err != ErrBreakerOpen {
+		t.Errorf("breaker after a failed probe = %v, want ErrBreakerOpen", err)
+	}
+}
+
+func TestConcurrencyLimit(t *testing.T) {
+	info := &ClientInfo{Service: "test.Limit", Method: "Do"}
+	ok := func(ctx context.Context, req, rsp any) error {
+		return nil
+	}
+	for _, limit := range []int{0, -1} {
+		if err := ConcurrencyLimit(limit)(context.Background(), info, nil, nil, ok); err != nil {
+			t.Errorf("ConcurrencyLimit(%d) = %v, want nil", limit, err)
+		}
+	}
+
+	limit := ConcurrencyLimit(1)
+	entered, release := make(chan struct{}), make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- limit(context.Background(), info, nil, nil, func(ctx context.Context, req, rsp any) error {
+			close(entered)
+			<-release
+			return nil
+		})
+	}()
+	<-entered
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := limit(ctx, info, nil, nil, ok); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("call over the limit = %v, want context.DeadlineExceeded", err)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if err := limit(context.Background(), info, nil, nil, ok); err != nil {
+		t.Errorf("call after the slot is freed = %v, want nil", err)
+	}
+}
+
+func TestListenAddress(t *testing.T) {
+	defer func() {
+		kcfg.Set("rpc.host", "")
+		kcfg.Set("rpc.port", 18972)
+		kcfg.Set("rpc.advertise", "")
+	}()
+
+	type listenTest struct {
+		name      string
+		network   string
+		host      string
+		port      int
+		advertise string
+		addr      string
+		expect    string
+		fails     bool
+	}
+	tests := []listenTest{
+		{name: "host only", network: "tcp", host: "10.0.0.5", port: 8972, addr: "10.0.0.5:8972", expect: "10.0.0.5:8972"},
+		{name: "host name", network: "tcp", host: "rpc.internal", port: 8972, addr: "rpc.internal:8972", expect: "rpc.internal:8972"},
+		{name: "ipv6 host", network: "tcp", host: "fd00::5", port: 8972, addr: "[fd00::5]:8972", expect: "[fd00::5]:8972"},
+		{name: "advertise override", network: "tcp", host: "0.0.0.0", port: 8972, advertise: "rpc.example.com", addr: "0.0.0.0:8972", expect: "rpc.example.com:8972"},
+		{name: "advertise with port", network: "tcp", host: "10.0.0.5", port: 8972, advertise: "rpc.example.com:443", addr: "10.0.0.5:8972", expect: "rpc.example.com:443"},
+		{name: "missing port", network: "tcp", host: "10.0.0.5", fails: true},
+		{name: "unix", network: "unix", host: "/tmp/rpc.sock", addr: "/tmp/rpc.sock", expect: "/tmp/rpc.sock"},
+		{name: "unix advertise", network: "unix", host: "/tmp/rpc.sock", advertise: "/run/rpc.sock", addr: "/tmp/rpc.sock", expect: "/run/rpc.sock"},
+		{name: "unix without path", network: "unix", fails: true},
+	}
+	if local, err := localIP(); err == nil {
+		tests = append(tests,
+			listenTest{name: "no host", network: "tcp", port: 8972, addr: ":8972", expect: net.JoinHostPort(local, "8972")},
+			listenTest{name: "unspecified host", network: "tcp", host: "0.0.0.0", port: 8972, addr: "0.0.0.0:8972", expect: net.JoinHostPort(local, "8972")},
+		)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kcfg.Set("rpc.host", tt.host)
+			kcfg.Set("rpc.port", tt.port)
+			kcfg.Set("rpc.advertise", tt.advertise)
+			addr, advertise, err := listenAddress(tt.network)
+			if tt.fails {
+				if err == nil {
+					t.Errorf("listenAddress() = %s %s, want an error", addr, advertise)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if addr != tt.addr || advertise != tt.expect {
+				t.Errorf("listenAddress() = %s %s, want %s %s", addr, advertise, tt.addr, tt.expect)
+			}
+		})
+	}
+}
+
+func TestLocalIP(t *testing.T) {
+	ip, err := localIP()
+	if err != nil {
+		t.Skip(err.Error())
+	}
+	parsed := net.ParseIP(ip)
+	if parsed == nil || parsed.IsLoopback() || parsed.IsLinkLocalUnicast() {
+		t.Errorf("localIP() = %s, want a routable address", ip)
+	}
+}
+
+func TestCodedError(t *testing.T) {
+	err := Call("test.Echo", "Missing", &EchoRequest{Msg: "x"}, &EchoResponse{})
+	e, ok := kerrors.FromError(err)
+	if !ok {
+		t.Fatalf("Call() = %v, want a kerrors.Error", err)
+	}
+	if e.Code != 404 || e.Details["msg"] != "x" {
+		t.Errorf("Call() = %+v, want code 404 with details", e)
+	}
+}
+
+func TestTrace(t *testing.T) {
+	trace := ktrace.Start("req-1", "")
+	ctx := ktrace.NewContext(context.Background(), trace)
+
+	rsp := &EchoResponse{}
+	if err := CallContext(ctx, "test.Echo", "Trace", &EchoRequest{}, rsp); err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Join([]string{"req-1", trace.TraceId, trace.SpanId}, " ")
+	if rsp.Msg != want {
+		t.Errorf("Trace() = %q, want %q", rsp.Msg, want)
+	}
+}
+
+func TestClientTransport(t *testing.T) {
+	registry, err := getRegistry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	memory := registry.(*MemoryRegistry)
+	nodes := map[string]string{"test.Quic": "quic@127.0.0.1:18973", "test.Kcp": "kcp@127.0.0.1:18974"}
+	for service, addr := range nodes {
+		memory.add(service, addr)
+		defer memory.remove(service, addr)
+	}
+
+	for service, missing := range map[string]string{"test.Quic": "TLSConfig", "test.Kcp": "Block"} {
+		if _, err = Client(service); err == nil || !strings.Contains(err.Error(), missing) {
+			t.Errorf("Client(%s) without a %s = %v", service, missing, err)
+		}
+	}
+
+	ConfigureClient("test.Quic", ClientConfig{TLSConfig: &tls.Config{ServerName: "test"}})
+	ConfigureClient("test.Kcp", ClientConfig{Block: &struct{}{}})
+	for service := range nodes {
+		if _, err = Client(service); err != nil {
+			t.Errorf("Client(%s) = %v", service, err)
+		}
+	}
+}
+
+type Slow struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (this *Slow) Wait(ctx context.Context, req *EchoRequest, rsp *EchoResponse) error {
+	close(this.started)
+	<-this.release
+	rsp.Msg = req.Msg
+	return nil
+}
+
+// serveSlow serves a Slow on addr, registered in its own MemoryRegistry, and
+// returns once a call is in flight on it.
+func serveSlow(t *testing.T, ctx context.Context, addr string) (memory *MemoryRegistry, slow *Slow, served, called chan error) {
+	memory = NewMemoryRegistry()
+	serv := server.NewServer()
+	serv.DisableHTTPGateway, serv.DisableJSONRPC = true, true
+	deregister, _ := memory.Register(serv, "tcp@"+addr)
+	slow = &Slow{started: make(chan struct{}), release: make(chan struct{})}
+	if err := serv.RegisterName("shutdown.Slow", slow, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	served = make(chan error, 1)
+	go func() {
+		served <- serve(ctx, serv, "tcp", addr, deregister)
+	}()
+	for serv.Address() == nil {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	d, _ := memory.Discovery("shutdown.Slow")
+	xclient := client.NewXClient("shutdown.Slow", client.Failfast, client.RandomSelect, d, client.DefaultOption)
+	t.Cleanup(func() {
+		_ = xclient.Close()
+	})
+	called = make(chan error, 1)
+	go func() {
+		rsp := &EchoResponse{}
+		err := xclient.Call(context.Background(), "Wait", &EchoRequest{Msg: "drained"}, rsp)
+		if err == nil && rsp.Msg != "drained" {
+			err = errors.New("unexpected response " + rsp.Msg)
+		}
+		called <- err
+	}()
+	<-slow.started
+	return
+}
+
+func TestServeShutdown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	memory, slow, served, called := serveSlow(t, ctx, "127.0.0.1:18975")
+	cancel()
+
+	// the node leaves the registry while the in-flight call drains
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if d, _ := memory.Discovery("shutdown.Slow"); len(d.GetServices()) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("node still registered during shutdown")
+		}
+	}
+	select {
+	case err := <-served:
+		t.Fatalf("serve() = %v before the in-flight call finished", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(slow.release)
+	if err := <-called; err != nil {
+		t.Errorf("in-flight call = %v", err)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("serve() = %v", err)
+	}
+}
+
+func TestServeShutdownTimeout(t *testing.T) {
+	timeout := kcfg.Get[any]("rpc.shutdownTimeout")
+	kcfg.Set("rpc.shutdownTimeout", "100ms")
+	defer kcfg.Set("rpc.shutdownTimeout", timeout)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	_, slow, served, called := serveSlow(t, ctx, "127.0.0.1:18976")
+	defer func() {
+		close(slow.release)
+		<-called
+	}()
+	cancel()
+
+	select {
+	case err := <-served:
+		if err == nil || !strings.Contains(err.Error(), context.DeadlineExceeded.Error()) {
+			t.Errorf("serve() = %v, want the shutdown deadline", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("serve() did not return within the shutdown timeout")
+	}
+}
@@ -0,0 +1,77 @@
+package rpc
+
+import (
+	"context"
+	"github.com/smallnest/rpcx/client"
+	"sort"
+	"sync"
+)
+
+type hashKeyCtxKey struct{}
+
+// hashSelector is the ConsistentHash selector, hashing calls by service,
+// method and arguments like rpcx does. Calls made WithHashKey are routed by
+// hashKeyPlugin before it.
+type hashSelector struct {
+	mu      sync.RWMutex
+	servers []string
+}
+
+func newHashSelector(pairs []*client.KVPair) *hashSelector {
+	servers := make(map[string]string)
+	for _, pair := range pairs {
+		servers[pair.Key] = pair.Value
+	}
+	s := &hashSelector{}
+	s.UpdateServer(servers)
+	return s
+}
+
+func (this *hashSelector) Select(ctx context.Context, servicePath, serviceMethod string, args interface{}) string {
+	this.mu.RLock()
+	defer this.mu.RUnlock()
+	if len(this.servers) == 0 {
+		return ""
+	}
+
+	return this.servers[client.JumpConsistentHash(len(this.servers), servicePath, serviceMethod, args)]
+}
+
+func (this *hashSelector) UpdateServer(servers map[string]string) {
+	ss := make([]string, 0, len(servers))
+	for k := range servers {
+		ss = append(ss, k)
+	}
+	sort.Strings(ss)
+
+	this.mu.Lock()
+	this.servers = ss
+	this.mu.Unlock()
+}
+
+// hashKeyPlugin routes the calls made WithHashKey by consistent hash on
+// their key whatever the select mode of the client, so the key applies per
+// call and needs no client of its own.
+type hashKeyPlugin struct {
+	discovery client.ServiceDiscovery
+}
+
+func (this *hashKeyPlugin) WrapSelect(fn client.SelectFunc) client.SelectFunc {
+	return func(ctx context.Context, servicePath, serviceMethod string, args interface{}) string {
+		key, ok := ctx.Value(hashKeyCtxKey{}).(string)
+		if !ok || key == "" {
+			return fn(ctx, servicePath, serviceMethod, args)
+		}
+
+		pairs := this.discovery.GetServices()
+		servers := make([]string, 0, len(pairs))
+		for _, pair := range pairs {
+			servers = append(servers, pair.Key)
+		}
+		if len(servers) == 0 {
+			return ""
+		}
+		sort.Strings(servers)
+		return servers[client.JumpConsistentHash(len(servers), key)]
+	}
+}
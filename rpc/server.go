@@ -2,21 +2,26 @@ package rpc
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/liushuochen/gotable"
 	"github.com/liushuochen/gotable/cell"
 	"github.com/liushuochen/gotable/table"
-	"github.com/rcrowley/go-metrics"
-	"github.com/rpcxio/rpcx-etcd/serverplugin"
 	"github.com/smallnest/rpcx/server"
 	"github.com/xinzf/kit/container/kcfg"
 	"github.com/xinzf/kit/container/kvar"
 	"github.com/xinzf/kit/klog"
-	"time"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
 )
 
 var _handlers []*handler
 
+var _serverOptions []server.OptionFn
+
 func init() {
 	_handlers = []*handler{}
 }
@@ -29,37 +34,54 @@ func Register(handlers ...interface{}) {
 	}
 }
 
-func Run(ctx context.Context, before ...func(serv *server.Server) error) {
+// ServerOptions appends rpcx options applied when Run creates the server,
+// such as server.WithTLSConfig, which the quic network requires, or
+// server.WithBlockCrypt for kcp. Both networks also need the matching
+// rpcx build tag, and their clients the TLSConfig or Block of
+// ConfigureClient.
+func ServerOptions(opts ...server.OptionFn) {
+	_serverOptions = append(_serverOptions, opts...)
+}
+
+// Run serves the registered handlers until ctx is done or the process
+// receives SIGINT/SIGTERM. It listens on rpc.network (tcp by default, also
+// unix, quic and kcp) at rpc.host:rpc.port and registers rpc.advertise, which
+// falls back to the first non-loopback address of the host. On shutdown the
+// services are removed from the registry first, then in-flight calls are
+// drained within rpc.shutdownTimeout.
+func Run(ctx context.Context, before ...func(serv *server.Server) error) error {
 	serviceName := kcfg.Get[string]("rpc.name")
 	if serviceName == "" {
-		klog.Fatal("Missing rpc service's name")
+		return errors.New("missing rpc service's name")
 	}
 
-	port := kcfg.Get[int]("rpc.port")
-	if port == 0 {
-		klog.Fatal("Missing rpc service's port")
+	network := kcfg.Get[string]("rpc.network")
+	if network == "" {
+		network = "tcp"
+	}
+	addr, advertise, err := listenAddress(network)
+	if err != nil {
+		return err
 	}
-	addr := fmt.Sprintf("localhost:%d", port)
 
-	var (
-		tb  *table.Table
-		err error
-	)
+	var tb *table.Table
 
 	tb, err = gotable.Create("#", "Service", "Method", "Handler")
 	if err != nil {
-		klog.Panicf("Create print table failed: %s", err.Error())
+		return fmt.Errorf("create print table failed: %s", err.Error())
 	}
 
-	serv := server.NewServer()
-	if err = register(serv, addr); err != nil {
-		klog.Fatal(err.Error())
+	serv := server.NewServer(_serverOptions...)
+	deregister, err := register(serv, network+"@"+advertise)
+	if err != nil {
+		return err
 	}
 
 	if len(before) > 0 {
 		for _, f := range before {
 			if err := f(serv); err != nil {
-				klog.Fatal(err.Error())
+				_ = deregister()
+				return err
 			}
 		}
 	}
@@ -84,51 +106,134 @@ func Run(ctx context.Context, before ...func(serv *server.Server) error) {
 				fmt.Sprintf("%s/%s", h.pkgPath, h.handlerName),
 			})
 		}
-		err = serv.RegisterName(fmt.Sprintf("%s.%s", serviceName, h.serviceName), h.hdl, "")
+		servicePath := fmt.Sprintf("%s.%s", serviceName, h.serviceName)
+		err = serv.RegisterName(servicePath, h.hdl, "")
 		if err != nil {
-			klog.Fatal(err.Error())
+			_ = deregister()
+			return err
+		}
+		for _, method := range h.methods {
+			serv.AddHandler(servicePath, method, h.dispatch(servicePath, method, _interceptors))
 		}
 	}
 
 	fmt.Println()
-	fmt.Printf("[SERVER] server listen on port: %d, Total: %d\n", port, num)
+	fmt.Printf("[SERVER] server listen on %s@%s, advertise: %s, Total: %d\n", network, addr, advertise, num)
 	fmt.Println(tb)
-	err = serv.Serve("tcp", addr)
+	return serve(ctx, serv, network, addr, deregister)
+}
+
+func serve(ctx context.Context, serv *server.Server, network, addr string, deregister func() error) error {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- serv.Serve(network, addr)
+	}()
+
+	select {
+	case err := <-errCh:
+		_ = deregister()
+		if errors.Is(err, server.ErrServerClosed) {
+			return nil
+		}
+		return err
+	case <-ctx.Done():
+	}
+
+	timeout := kvar.New(kcfg.Get[any]("rpc.shutdownTimeout")).Duration()
+	klog.Args("timeout", timeout.String()).Info("Rpc server is shutting down")
+
+	// remove the node from the registry before draining, so clients stop
+	// routing new calls to it while the in-flight ones finish.
+	if err := serv.UnregisterAll(); err != nil {
+		klog.Args("err", err.Error()).Warn("Unregister rpc services failed")
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err := serv.Shutdown(shutdownCtx)
+	if stopErr := deregister(); stopErr != nil && err == nil {
+		err = stopErr
+	}
+	if closeErr := Close(); closeErr != nil && err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("rpc server shutdown failed: %s", err.Error())
+	}
+	return nil
+}
+
+func register(serv *server.Server, serviceAddr string) (deregister func() error, err error) {
+	registry, err := getRegistry()
 	if err != nil {
-		klog.Fatal(err.Error())
+		return nil, err
 	}
+	return registry.Register(serv, serviceAddr)
 }
 
-func register(serv *server.Server, addr string) error {
+// listenAddress resolves the address to listen on from rpc.host and rpc.port
+// and the one to publish from rpc.advertise. For unix sockets rpc.host is
+// the socket path and rpc.port is unused.
+func listenAddress(network string) (addr, advertise string, err error) {
+	host := kcfg.Get[string]("rpc.host")
+	advertise = kcfg.Get[string]("rpc.advertise")
+
+	if network == "unix" {
+		if host == "" {
+			return "", "", errors.New("missing rpc service's socket path in rpc.host")
+		}
+		if advertise == "" {
+			advertise = host
+		}
+		return host, advertise, nil
+	}
+
+	port := kcfg.Get[int]("rpc.port")
+	if port == 0 {
+		return "", "", errors.New("missing rpc service's port")
+	}
+	addr = net.JoinHostPort(host, strconv.Itoa(port))
 
-	basePath := kcfg.Get[string]("base")
-	if basePath == "" {
-		basePath = "gokit"
+	if advertise == "" {
+		if ip := net.ParseIP(host); host != "" && (ip == nil || !ip.IsUnspecified()) {
+			advertise = host
+		} else if advertise, err = localIP(); err != nil {
+			return "", "", err
+		}
+	}
+	if _, _, splitErr := net.SplitHostPort(advertise); splitErr != nil {
+		advertise = net.JoinHostPort(advertise, strconv.Itoa(port))
 	}
+	return addr, advertise, nil
+}
 
-	registry := kcfg.Get[string]("registry")
-	if registry == "" {
-		registry = "etcd"
+// localIP returns the first non-loopback IPv4 address of the host, or the
+// first IPv6 one when there is no IPv4 address.
+func localIP() (string, error) {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return "", err
 	}
 
-	switch registry {
-	case "etcd":
-		etcdAddrs := kvar.New(kcfg.Get[[]any]("rpc.etcd.addrs")).Strings()
-		if etcdAddrs == nil || len(etcdAddrs) == 0 {
-			klog.Fatal("Missing etcd addrs")
+	var v6 string
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLoopback() || ipNet.IP.IsLinkLocalUnicast() {
+			continue
 		}
-		reg := &serverplugin.EtcdV3RegisterPlugin{
-			ServiceAddress: "tcp@" + addr,
-			EtcdServers:    etcdAddrs,
-			BasePath:       basePath,
-			Metrics:        metrics.NewRegistry(),
-			UpdateInterval: time.Second * 30,
+		if ipNet.IP.To4() != nil {
+			return ipNet.IP.String(), nil
 		}
-		if err := reg.Start(); err != nil {
-			return err
+		if v6 == "" {
+			v6 = ipNet.IP.String()
 		}
-
-		serv.Plugins.Add(reg)
 	}
-	return nil
+	if v6 != "" {
+		return v6, nil
+	}
+	return "", errors.New("no non-loopback address to advertise, please set rpc.advertise")
 }
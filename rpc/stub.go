@@ -0,0 +1,130 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Invoke calls service.method and decodes the response into a new Rsp.
+func Invoke[Req, Rsp any](ctx context.Context, service, method string, req *Req, opts ...CallOption) (*Rsp, error) {
+	rsp := new(Rsp)
+	if err := CallContext(ctx, service, method, req, rsp, opts...); err != nil {
+		return nil, err
+	}
+	return rsp, nil
+}
+
+var (
+	ctxType     = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errType     = reflect.TypeOf((*error)(nil)).Elem()
+	optionsType = reflect.TypeOf([]CallOption{})
+)
+
+// NewStub returns an S whose func fields call the methods of the same name on
+// service. Every field must look like
+//
+//	Method func(ctx context.Context, req *Req, opts ...CallOption) (*Rsp, error)
+//
+// where opts is optional. When handler is not nil, typically a nil pointer of
+// the server side handler type, each field is also checked against the
+// handler method it calls.
+func NewStub[S any](service string, handler any) (*S, error) {
+	stub := new(S)
+	stubValue := reflect.ValueOf(stub).Elem()
+	if stubValue.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("stub %s is not a struct", stubValue.Type())
+	}
+
+	var handlerType reflect.Type
+	if handler != nil {
+		handlerType = reflect.TypeOf(handler)
+	}
+
+	problems := make([]string, 0)
+	for i := 0; i < stubValue.NumField(); i++ {
+		field := stubValue.Type().Field(i)
+		if !field.IsExported() || field.Type.Kind() != reflect.Func {
+			continue
+		}
+
+		if err := checkStubField(field.Type, handlerType, field.Name); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s", field.Name, err.Error()))
+			continue
+		}
+		stubValue.Field(i).Set(makeStubFunc(service, field.Name, field.Type))
+	}
+
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("invalid stub %s: %s", stubValue.Type(), strings.Join(problems, "; "))
+	}
+	return stub, nil
+}
+
+func checkStubField(fn, handlerType reflect.Type, name string) error {
+	numIn := fn.NumIn()
+	if fn.IsVariadic() {
+		if fn.In(numIn-1) != optionsType {
+			return fmt.Errorf("variadic argument is not ...rpc.CallOption")
+		}
+		numIn--
+	}
+	if numIn != 2 || fn.In(0) != ctxType || fn.In(1).Kind() != reflect.Ptr {
+		return fmt.Errorf("arguments are not (context.Context, *Req)")
+	}
+	if fn.NumOut() != 2 || fn.Out(0).Kind() != reflect.Ptr || fn.Out(1) != errType {
+		return fmt.Errorf("results are not (*Rsp, error)")
+	}
+
+	if handlerType == nil {
+		return nil
+	}
+
+	method, found := handlerType.MethodByName(name)
+	if !found {
+		return fmt.Errorf("%s has no such method", handlerType)
+	}
+
+	if method.Type.NumOut() != 1 || method.Type.Out(0) != errType {
+		return fmt.Errorf("%s.%s is not an rpc handler: it must return only an error", handlerType, name)
+	}
+
+	// the method of a type carries the receiver as its first argument
+	args := make([]reflect.Type, 0, method.Type.NumIn()-1)
+	for i := 1; i < method.Type.NumIn(); i++ {
+		args = append(args, method.Type.In(i))
+	}
+	methodType := reflect.FuncOf(args, []reflect.Type{method.Type.Out(0)}, false)
+	if err := checkMethod(methodType); err != nil {
+		return fmt.Errorf("%s.%s is not an rpc handler: %s", handlerType, name, err.Error())
+	}
+	if methodType.In(1) != fn.In(1) {
+		return fmt.Errorf("request is %s, handler takes %s", fn.In(1), methodType.In(1))
+	}
+	if methodType.In(2) != fn.Out(0) {
+		return fmt.Errorf("response is %s, handler takes %s", fn.Out(0), methodType.In(2))
+	}
+	return nil
+}
+
+func makeStubFunc(service, method string, fn reflect.Type) reflect.Value {
+	return reflect.MakeFunc(fn, func(args []reflect.Value) []reflect.Value {
+		ctx, _ := args[0].Interface().(context.Context)
+		if ctx == nil {
+			ctx = context.Background()
+		}
+
+		var opts []CallOption
+		if fn.IsVariadic() {
+			opts = args[2].Interface().([]CallOption)
+		}
+
+		rsp := reflect.New(fn.Out(0).Elem())
+		err := CallContext(ctx, service, method, args[1].Interface(), rsp.Interface(), opts...)
+		if err != nil {
+			return []reflect.Value{reflect.Zero(fn.Out(0)), reflect.ValueOf(&err).Elem()}
+		}
+		return []reflect.Value{rsp, reflect.Zero(errType)}
+	})
+}
@@ -0,0 +1,401 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/liushuochen/gotable"
+	"github.com/liushuochen/gotable/cell"
+	"github.com/xinzf/kit/container/kcfg"
+	"github.com/xinzf/kit/container/kvar"
+	"github.com/xinzf/kit/klog"
+	"github.com/xinzf/kit/server/storage"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config configures an App. LoadConfig reads it from a config section.
+type Config struct {
+	Port int
+	// Debug runs gin in debug mode. The mode is process-wide, the first App
+	// to Run sets it.
+	Debug bool
+	// ShutdownTimeout bounds the graceful shutdown of Run.
+	ShutdownTimeout time.Duration
+	// MethodPrefix derives the HTTP verb of handler methods from their name
+	// prefix: GetUser, DeleteUser.
+	MethodPrefix bool
+	// HTTPStatus sends response statuses as HTTP status codes, see
+	// HTTPStatus.
+	HTTPStatus bool
+	// Locale is the fallback locale of validation messages.
+	Locale string
+	// Strict fails Run, and panics Handler, on invalid handler signatures,
+	// duplicate routes and alias collisions instead of logging them.
+	Strict bool
+	// CORS installs the CORS middleware when not nil.
+	CORS    *CORSConfig
+	OpenAPI OpenAPIConfig
+	Upload  UploadConfig
+}
+
+// OpenAPIConfig configures the OpenAPI document served by an App.
+type OpenAPIConfig struct {
+	// Path serves the viewer, Path/openapi.json and Path/openapi.yaml.
+	// Empty disables them.
+	Path        string
+	Title       string
+	Version     string
+	Description string
+	// Assets is the base URL of the swagger-ui-dist files of the viewer:
+	// files served locally, or a CDN pinned to an exact version such as
+	// https://unpkg.com/swagger-ui-dist@<version>. Empty serves links to
+	// the documents instead, so no third-party script is loaded by default.
+	Assets string
+}
+
+// LoadConfig reads the config section key, server for the default App.
+func LoadConfig(key string) Config {
+	cfg := Config{
+		Port:            kcfg.Get[int](key + ".port"),
+		Debug:           kcfg.Get[bool](key + ".debug"),
+		ShutdownTimeout: kvar.New(kcfg.Get[any](key + ".shutdownTimeout")).Duration(),
+		MethodPrefix:    kcfg.Get[bool](key + ".methodPrefix"),
+		HTTPStatus:      kcfg.Get[bool](key + ".httpStatus"),
+		Locale:          kcfg.Get[string](key + ".locale"),
+		Strict:          kcfg.Get[bool](key + ".strict"),
+		OpenAPI: OpenAPIConfig{
+			Path:        kcfg.Get[string](key + ".openapi.path"),
+			Title:       kcfg.Get[string](key + ".openapi.title"),
+			Version:     kcfg.Get[string](key + ".openapi.version"),
+			Description: kcfg.Get[string](key + ".openapi.description"),
+			Assets:      kcfg.Get[string](key + ".openapi.assets"),
+		},
+		Upload: UploadConfig{
+			MaxRequestSize:  parseSize(kcfg.Get[any](key + ".upload.maxRequestSize")),
+			MaxFileSize:     parseSize(kcfg.Get[any](key + ".upload.maxFileSize")),
+			MaxMemory:       parseSize(kcfg.Get[any](key + ".upload.maxMemory")),
+			AllowTypes:      kvar.New(kcfg.Get[any](key + ".upload.allowTypes")).Strings(),
+			AllowExtensions: kvar.New(kcfg.Get[any](key + ".upload.allowExtensions")).Strings(),
+			Resumable:       kcfg.Get[bool](key + ".upload.resumable"),
+			ChunkDir:        kcfg.Get[string](key + ".upload.chunkDir"),
+			ChunkSize:       parseSize(kcfg.Get[any](key + ".upload.chunkSize")),
+			ChunkTTL:        kvar.New(kcfg.Get[any](key + ".upload.chunkTTL")).Duration(),
+		},
+	}
+	if cors, found := corsConfig(key + ".cors"); found {
+		cfg.CORS = &cors
+	}
+
+	if cfg.Port == 0 {
+		cfg.Port = 8080
+	}
+	if cfg.ShutdownTimeout <= 0 {
+		cfg.ShutdownTimeout = 10 * time.Second
+	}
+	if cfg.OpenAPI.Title == "" {
+		cfg.OpenAPI.Title = kcfg.Get[string]("name")
+	}
+	if cfg.OpenAPI.Version == "" {
+		cfg.OpenAPI.Version = "1.0.0"
+	}
+	return cfg
+}
+
+// parseSize reads a size in bytes, given as a number or with a KB, MB or GB
+// suffix: 10MB.
+func parseSize(val any) int64 {
+	str := strings.ToUpper(strings.TrimSpace(kvar.New(val).String()))
+	unit := int64(1)
+	for suffix, size := range map[string]int64{"KB": 1 << 10, "MB": 1 << 20, "GB": 1 << 30} {
+		if strings.HasSuffix(str, suffix) {
+			str, unit = strings.TrimSpace(strings.TrimSuffix(str, suffix)), size
+			break
+		}
+	}
+	str = strings.TrimSuffix(str, "B")
+	return kvar.New(str).Int64() * unit
+}
+
+// App is an http server with its own groups, middlewares and config, so
+// several can run in one process. The package level Group, Run and
+// OnShutdown use a default App configured by the server section.
+type App struct {
+	configKey       string
+	cfgMu           sync.Mutex
+	cfg             *Config
+	middlewares     []gin.HandlerFunc
+	groups          []*HandlerGroup
+	shutdownHooks   []func(ctx context.Context) error
+	responseFactory ResponseFactory
+	statusMapper    StatusMapper
+
+	chunkMu      sync.Mutex
+	chunkStore   ChunkStore
+	chunkStorage storage.Storage
+
+	buildOnce sync.Once
+	buildErr  error
+	engine    *gin.Engine
+	routes    [][]string
+}
+
+// Option configures an App.
+type Option func(app *App)
+
+// WithConfig sets the config of the App.
+func WithConfig(cfg Config) Option {
+	return func(app *App) {
+		app.cfg = &cfg
+	}
+}
+
+// WithConfigKey reads the config of the App from the section key when it
+// starts, server by default.
+func WithConfigKey(key string) Option {
+	return func(app *App) {
+		app.configKey = key
+	}
+}
+
+// WithMiddlewares adds middlewares running before every route of the App,
+// after the built-in trace, access log and recovery ones.
+func WithMiddlewares(middlewares ...gin.HandlerFunc) Option {
+	return func(app *App) {
+		app.middlewares = append(app.middlewares, middlewares...)
+	}
+}
+
+// WithResponseFactory replaces the default {status, msg, data} envelope.
+func WithResponseFactory(factory ResponseFactory) Option {
+	return func(app *App) {
+		app.responseFactory = factory
+	}
+}
+
+// WithChunkStore enables resumable uploads, keeping their state in store and
+// their chunks in chunks.
+func WithChunkStore(store ChunkStore, chunks storage.Storage) Option {
+	return func(app *App) {
+		app.chunkStore = store
+		app.chunkStorage = chunks
+	}
+}
+
+// WithStatusMapper sets how response statuses become HTTP status codes.
+func WithStatusMapper(mapper StatusMapper) Option {
+	return func(app *App) {
+		app.statusMapper = mapper
+	}
+}
+
+// New returns an App reading its config from the server section unless an
+// option says otherwise.
+func New(opts ...Option) *App {
+	app := &App{
+		configKey:     "server",
+		middlewares:   []gin.HandlerFunc{},
+		groups:        []*HandlerGroup{},
+		shutdownHooks: []func(ctx context.Context) error{},
+	}
+	for _, opt := range opts {
+		opt(app)
+	}
+	return app
+}
+
+var defaultApp = New()
+
+// Config returns the config of the App, read from its config section on
+// first use unless WithConfig set it.
+func (this *App) Config() Config {
+	this.cfgMu.Lock()
+	defer this.cfgMu.Unlock()
+	if this.cfg == nil {
+		cfg := LoadConfig(this.configKey)
+		this.cfg = &cfg
+	}
+	return *this.cfg
+}
+
+// Group adds a group of handlers served under path.
+func (this *App) Group(path string, middlewares ...gin.HandlerFunc) *HandlerGroup {
+	g := &HandlerGroup{
+		app:         this,
+		path:        path,
+		middlewares: middlewares,
+		handlers:    []*handler{},
+		subGroups:   []*HandlerGroup{},
+	}
+	this.groups = append(this.groups, g)
+	return g
+}
+
+// OnShutdown registers hooks which are called, in order, after the App has
+// stopped accepting requests and drained the in-flight ones.
+func (this *App) OnShutdown(hooks ...func(ctx context.Context) error) {
+	this.shutdownHooks = append(this.shutdownHooks, hooks...)
+}
+
+// Handler returns the router of the App, for httptest or another server.
+// Routes are bound on the first call, groups registered later are not
+// served. It panics with a *RegistrationError in strict mode.
+func (this *App) Handler() http.Handler {
+	if err := this.build(); err != nil {
+		panic(err)
+	}
+	return this.engine
+}
+
+const appContextKey = "kit.server.app"
+
+// appOf returns the App serving c, the default App for routers built by hand.
+func appOf(c *gin.Context) *App {
+	if app, found := c.Get(appContextKey); found {
+		return app.(*App)
+	}
+	return defaultApp
+}
+
+// build binds the routes of the App once. Registration problems fail it in
+// strict mode and are logged otherwise.
+func (this *App) build() error {
+	this.buildOnce.Do(func() {
+		cfg := this.Config()
+
+		g := gin.New()
+		// let *gin.Context carry the request context, and so the trace, into
+		// rpc calls and klog
+		g.ContextWithFallback = true
+		g.Use(func(c *gin.Context) {
+			c.Set(appContextKey, this)
+		})
+		g.Use(Trace(), AccessLog(), Recovery())
+		if cfg.CORS != nil {
+			g.Use(CORS(*cfg.CORS))
+		}
+		g.Use(this.middlewares...)
+
+		var problems []string
+		bound := make(map[string]string)
+
+		var listen func(ginGroup *gin.RouterGroup, _group *HandlerGroup)
+		listen = func(ginGroup *gin.RouterGroup, _group *HandlerGroup) {
+			problems = append(problems, _group.problems...)
+
+			aliases := make(map[string]string)
+			for _, h := range _group.handlers {
+				name := h.handlerName
+				if h.aliasName != "" {
+					name = h.aliasName
+				}
+				owner := fmt.Sprintf("%s/%s", h.pkgPath, h.handlerName)
+				if other, found := aliases[snakeString(name)]; found && other != owner {
+					problems = append(problems, fmt.Sprintf("%s and %s are both routed as %s in group /%s", other, owner, snakeString(name), _group.getPath()))
+				}
+				aliases[snakeString(name)] = owner
+			}
+
+			for _, h := range _group.handlers {
+				path, fullPath := _group.routePath(h)
+				method := fmt.Sprintf("%s.%s", h.handlerName, h.methodName)
+				verbs := make([]string, 0)
+				for _, verb := range h.getVerbs(cfg.MethodPrefix) {
+					route := verb + " " + fullPath
+					if other, found := bound[route]; found {
+						problems = append(problems, fmt.Sprintf("%s is bound by both %s and %s", route, other, method))
+						continue
+					}
+					bound[route] = method
+					verbs = append(verbs, verb)
+				}
+				if len(verbs) == 0 {
+					continue
+				}
+
+				this.routes = append(this.routes, []string{
+					fmt.Sprintf("%d", len(this.routes)+1),
+					strings.Join(verbs, ","),
+					fullPath,
+					fmt.Sprintf("%s/%s", h.pkgPath, h.handlerName),
+					h.methodName,
+				})
+
+				for _, verb := range verbs {
+					ginGroup.Handle(verb, path, h.handlerFunc)
+				}
+			}
+			for _, subGroup := range _group.subGroups {
+				_subGinGroup := ginGroup.Group(subGroup.path, subGroup.middlewares...)
+				listen(_subGinGroup, subGroup)
+			}
+		}
+
+		for _, _group := range this.groups {
+			listen(g.Group(_group.path, _group.middlewares...), _group)
+		}
+
+		if len(problems) > 0 {
+			if cfg.Strict {
+				this.buildErr = &RegistrationError{Problems: problems}
+				return
+			}
+			for _, problem := range problems {
+				klog.Args("problem", problem).Warn("Handler registration problem")
+			}
+		}
+		this.serveOpenAPI(g, cfg.OpenAPI)
+		this.engine = g
+	})
+	return this.buildErr
+}
+
+// ginModeOnce lets the first App to run set the process-wide gin mode, so
+// Apps running side by side do not flip it under each other.
+var ginModeOnce sync.Once
+
+// Run serves the App until ctx is done or the process receives
+// SIGINT/SIGTERM, then shuts it down gracefully within its ShutdownTimeout.
+func (this *App) Run(ctx context.Context, before ...func() error) error {
+	for _, f := range before {
+		if err := f(); err != nil {
+			return err
+		}
+	}
+
+	cfg := this.Config()
+	ginModeOnce.Do(func() {
+		if cfg.Debug {
+			gin.SetMode(gin.DebugMode)
+		} else {
+			gin.SetMode(gin.ReleaseMode)
+		}
+	})
+	if err := this.build(); err != nil {
+		return err
+	}
+
+	tb, err := gotable.Create("#", "Verb", "URI", "Handler", "Method")
+	if err != nil {
+		return fmt.Errorf("create print table failed: %s", err.Error())
+	}
+	for _, column := range []string{"#", "Verb", "URI", "Handler", "Method"} {
+		tb.Align(column, cell.AlignLeft)
+		tb.SetColumnColor(column, gotable.Underline, gotable.Write, gotable.NoneBackground)
+	}
+	for _, row := range this.routes {
+		_ = tb.AddRow(row)
+	}
+
+	fmt.Println()
+	fmt.Printf("[SERVER] server listen on port: %d, Total: %d\n", cfg.Port, len(this.routes))
+	fmt.Println(tb)
+
+	srv := &http.Server{
+		Addr:    fmt.Sprintf(":%d", cfg.Port),
+		Handler: this.engine,
+	}
+	return this.serve(ctx, srv, cfg.ShutdownTimeout)
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+type appRequest struct{}
+
+type appResponse struct {
+	Pong bool `json:"pong"`
+}
+
+type appHandler struct{}
+
+func (this *appHandler) Ping(req *appRequest, rsp *appResponse) error {
+	rsp.Pong = true
+	return nil
+}
+
+func TestApps(t *testing.T) {
+	tagged := func(c *gin.Context) {
+		c.Header("X-App", "b")
+	}
+	a := New(WithConfig(Config{}))
+	b := New(WithConfig(Config{HTTPStatus: true}), WithMiddlewares(tagged))
+	a.Group("/a").Register(new(appHandler))
+	b.Group("/b").Register(new(appHandler))
+
+	tests := []struct {
+		app  *App
+		path string
+		code int
+		tag  string
+	}{
+		{a, "/a/app_handler/ping", http.StatusOK, ""},
+		{a, "/b/app_handler/ping", http.StatusNotFound, ""},
+		{b, "/b/app_handler/ping", http.StatusOK, "b"},
+		{b, "/a/app_handler/ping", http.StatusNotFound, "b"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		tt.app.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if w.Code != tt.code || w.Header().Get("X-App") != tt.tag {
+			t.Errorf("GET %s = %d, X-App %q", tt.path, w.Code, w.Header().Get("X-App"))
+		}
+	}
+
+}
+
+type strictHandler struct {
+	_ struct{} `ignore:"Tagged"`
+}
+
+func (this *strictHandler) IgnoreMethods() []string {
+	return []string{"Helper"}
+}
+
+func (this *strictHandler) Ping(req *appRequest, rsp *appResponse) error {
+	return nil
+}
+
+func (this *strictHandler) Typo(req *appRequest, rsp *appResponse) string {
+	return ""
+}
+
+func (this *strictHandler) Helper(n int) int {
+	return n
+}
+
+func (this *strictHandler) Tagged(name string) string {
+	return name
+}
+
+type aliasedHandler struct{}
+
+func (this *aliasedHandler) HandlerName() string {
+	return "strictHandler"
+}
+
+func (this *aliasedHandler) Pong(req *appRequest, rsp *appResponse) error {
+	return nil
+}
+
+func TestStrict(t *testing.T) {
+	register := func(app *App) {
+		app.Group("/a").Register(new(strictHandler), new(strictHandler), new(aliasedHandler))
+		app.Group("/a").Register(new(strictHandler))
+	}
+
+	strict := New(WithConfig(Config{Strict: true}))
+	register(strict)
+	err := strict.build()
+	var registrationErr *RegistrationError
+	if !errors.As(err, &registrationErr) {
+		t.Fatalf("build error = %v", err)
+	}
+	want := []string{
+		"strictHandler.Typo: the output error is not error type",
+		"github.com/xinzf/kit/server/strictHandler and github.com/xinzf/kit/server/aliasedHandler are both routed as strict_handler in group /a",
+		"strictHandler.Typo: the output error is not error type",
+		"GET /a/strict_handler/ping is bound by both strictHandler.Ping and strictHandler.Ping",
+		"POST /a/strict_handler/ping is bound by both strictHandler.Ping and strictHandler.Ping",
+	}
+	if !reflect.DeepEqual(registrationErr.Problems, want) {
+		t.Errorf("problems = %q", registrationErr.Problems)
+	}
+
+	lax := New(WithConfig(Config{}))
+	register(lax)
+	w := httptest.NewRecorder()
+	lax.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/a/strict_handler/ping", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("GET ping = %d", w.Code)
+	}
+}
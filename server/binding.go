@@ -0,0 +1,120 @@
+package server
+
+import (
+	"encoding/xml"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	jsoniter "github.com/json-iterator/go"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// bindRequest fills req from, in order, the query string and form body
+// (form tags), the path params (uri tags), the headers (header tags) and a
+// JSON or XML body. Later sources override earlier ones. Only fields with
+// an explicit form, uri or header tag are bound from the first three.
+func bindRequest(c *gin.Context, req any) error {
+	if isStructPtr(req) {
+		if err := bindForm(c, req); err != nil {
+			return err
+		}
+
+		typ := reflect.TypeOf(req).Elem()
+		params := taggedValues(typ, "uri", func(name string) []string {
+			if value, found := c.Params.Get(name); found {
+				return []string{value}
+			}
+			return nil
+		})
+		if err := binding.MapFormWithTag(req, params, "uri"); err != nil {
+			return err
+		}
+
+		if err := binding.MapFormWithTag(req, taggedValues(typ, "header", c.Request.Header.Values), "header"); err != nil {
+			return err
+		}
+	}
+
+	if c.Request.Body == nil || c.Request.Body == http.NoBody {
+		return nil
+	}
+
+	var err error
+	switch c.ContentType() {
+	case "application/json":
+		err = jsoniter.NewDecoder(c.Request.Body).Decode(req)
+	case "text/xml", "application/xml":
+		err = xml.NewDecoder(c.Request.Body).Decode(req)
+	}
+	if errors.Is(err, io.EOF) {
+		err = nil
+	}
+	return err
+}
+
+func bindForm(c *gin.Context, req any) error {
+	switch c.ContentType() {
+	case "multipart/form-data":
+		if _, err := c.MultipartForm(); err != nil {
+			return err
+		}
+	default:
+		if err := c.Request.ParseForm(); err != nil {
+			return err
+		}
+	}
+	values := taggedValues(reflect.TypeOf(req).Elem(), "form", func(name string) []string {
+		return c.Request.Form[name]
+	})
+	return binding.MapFormWithTag(req, values, "form")
+}
+
+// taggedValues collects the values of the fields of typ carrying an explicit
+// tag, looked up by the tag name. gin binds untagged fields by their Go name,
+// so leaving their names out keeps clients from setting fields that are not
+// meant to be bound, such as ones hidden from the body with json:"-".
+func taggedValues(typ reflect.Type, tag string, lookup func(name string) []string) map[string][]string {
+	values := make(map[string][]string)
+	visited := make(map[reflect.Type]bool)
+	var collect func(typ reflect.Type)
+	collect = func(typ reflect.Type) {
+		if visited[typ] {
+			return
+		}
+		visited[typ] = true
+		for i := 0; i < typ.NumField(); i++ {
+			field := typ.Field(i)
+			fieldType := field.Type
+			if fieldType.Kind() == reflect.Ptr {
+				fieldType = fieldType.Elem()
+			}
+
+			name := strings.Split(field.Tag.Get(tag), ",")[0]
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				if fieldType.Kind() == reflect.Struct && fieldType != timeType {
+					collect(fieldType)
+				}
+				continue
+			}
+			if vals := lookup(name); len(vals) > 0 {
+				values[name] = vals
+			}
+		}
+	}
+	collect(typ)
+	return values
+}
+
+func isStructPtr(v any) bool {
+	typ := reflect.TypeOf(v)
+	return typ != nil && typ.Kind() == reflect.Ptr && typ.Elem().Kind() == reflect.Struct
+}
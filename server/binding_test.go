@@ -0,0 +1,153 @@
+package server
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type bindingRequest struct {
+	Id    int    `form:"id" uri:"id" header:"X-Id" json:"id" xml:"id"`
+	Name  string `form:"name" json:"name" xml:"name"`
+	Token string `header:"x-token"`
+	Page  int    `form:"page"`
+}
+
+func bindingContext(method, target, contentType, body string, params gin.Params, header map[string]string) *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	var r *http.Request
+	if body == "" {
+		r = httptest.NewRequest(method, target, nil)
+	} else {
+		r = httptest.NewRequest(method, target, strings.NewReader(body))
+	}
+	if contentType != "" {
+		r.Header.Set("Content-Type", contentType)
+	}
+	for name, value := range header {
+		r.Header.Set(name, value)
+	}
+	c.Request = r
+	c.Params = params
+	return c
+}
+
+func TestBindRequestPrecedence(t *testing.T) {
+	tests := []struct {
+		name   string
+		c      *gin.Context
+		expect bindingRequest
+	}{
+		{
+			name:   "query",
+			c:      bindingContext(http.MethodGet, "/users?id=1&name=query&page=2", "", "", nil, nil),
+			expect: bindingRequest{Id: 1, Name: "query", Page: 2},
+		},
+		{
+			name:   "form over query",
+			c:      bindingContext(http.MethodPost, "/users?id=1&name=query", "application/x-www-form-urlencoded", "name=form", nil, nil),
+			expect: bindingRequest{Id: 1, Name: "form"},
+		},
+		{
+			name:   "uri over form",
+			c:      bindingContext(http.MethodGet, "/users/2?id=1", "", "", gin.Params{{Key: "id", Value: "2"}}, nil),
+			expect: bindingRequest{Id: 2},
+		},
+		{
+			name:   "header over uri",
+			c:      bindingContext(http.MethodGet, "/users/2?id=1", "", "", gin.Params{{Key: "id", Value: "2"}}, map[string]string{"X-Id": "3", "X-Token": "secret"}),
+			expect: bindingRequest{Id: 3, Token: "secret"},
+		},
+		{
+			name:   "json over header",
+			c:      bindingContext(http.MethodPost, "/users?name=query", "application/json", `{"id":4}`, nil, map[string]string{"X-Id": "3"}),
+			expect: bindingRequest{Id: 4, Name: "query"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req bindingRequest
+			if err := bindRequest(tt.c, &req); err != nil {
+				t.Fatal(err)
+			}
+			if req != tt.expect {
+				t.Errorf("bindRequest() = %+v, want %+v", req, tt.expect)
+			}
+		})
+	}
+}
+
+func TestBindRequestConversionErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		c    *gin.Context
+	}{
+		{"query", bindingContext(http.MethodGet, "/users?page=two", "", "", nil, nil)},
+		{"uri", bindingContext(http.MethodGet, "/users/x", "", "", gin.Params{{Key: "id", Value: "x"}}, nil)},
+		{"header", bindingContext(http.MethodGet, "/users", "", "", nil, map[string]string{"X-Id": "x"})},
+		{"json", bindingContext(http.MethodPost, "/users", "application/json", `{"id":"x"}`, nil, nil)},
+		{"invalid json", bindingContext(http.MethodPost, "/users", "application/json", `{"id":`, nil, nil)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req bindingRequest
+			if err := bindRequest(tt.c, &req); err == nil {
+				t.Errorf("bindRequest() = nil, want an error, bound %+v", req)
+			}
+		})
+	}
+}
+
+func TestBindRequestBody(t *testing.T) {
+	tests := []struct {
+		contentType string
+		body        string
+		expect      bindingRequest
+	}{
+		{"application/json", `{"id":1,"name":"json"}`, bindingRequest{Id: 1, Name: "json"}},
+		{"application/json; charset=utf-8", `{"name":"charset"}`, bindingRequest{Name: "charset"}},
+		{"application/xml", `<bindingRequest><id>2</id><name>xml</name></bindingRequest>`, bindingRequest{Id: 2, Name: "xml"}},
+		{"text/xml", `<bindingRequest><name>text</name></bindingRequest>`, bindingRequest{Name: "text"}},
+		{"text/plain", `{"name":"ignored"}`, bindingRequest{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.contentType, func(t *testing.T) {
+			var req bindingRequest
+			c := bindingContext(http.MethodPost, "/users", tt.contentType, tt.body, nil, nil)
+			if err := bindRequest(c, &req); err != nil {
+				t.Fatal(err)
+			}
+			if req != tt.expect {
+				t.Errorf("bindRequest() = %+v, want %+v", req, tt.expect)
+			}
+		})
+	}
+
+	var req bindingRequest
+	c := bindingContext(http.MethodPost, "/users", "application/json", "", nil, nil)
+	if err := bindRequest(c, &req); err != nil {
+		t.Errorf("bindRequest() without a body = %v", err)
+	}
+}
+
+type bindingAccount struct {
+	Name    string `form:"name" json:"name"`
+	IsAdmin bool   `json:"-"`
+	Role    string
+	Secret  string `form:"-" json:"secret"`
+}
+
+func TestBindRequestUntagged(t *testing.T) {
+	c := bindingContext(http.MethodPost, "/accounts?name=kit&IsAdmin=true&Role=admin&Secret=s&-=x", "application/x-www-form-urlencoded",
+		"IsAdmin=true&Role=admin", gin.Params{{Key: "IsAdmin", Value: "true"}, {Key: "Role", Value: "admin"}},
+		map[string]string{"IsAdmin": "true", "Role": "admin"})
+	var req bindingAccount
+	if err := bindRequest(c, &req); err != nil {
+		t.Fatal(err)
+	}
+	if req != (bindingAccount{Name: "kit"}) {
+		t.Errorf("bindRequest() = %+v, want only the tagged name bound", req)
+	}
+}
@@ -0,0 +1,372 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/xinzf/kit/klog"
+	"golang.org/x/sync/singleflight"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CacheStore keeps the responses of requests implementing
+// RequestCacheSetter. Redis backs it unless SetCacheStore installs another.
+type CacheStore interface {
+	// Get returns the value of key, found is false when it is missing.
+	Get(ctx context.Context, key string) (value []byte, found bool, err error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Delete removes the keys matching the patterns, which use the redis
+	// glob syntax: user:*:profile.
+	Delete(ctx context.Context, patterns ...string) error
+}
+
+var (
+	_cacheStore   CacheStore
+	_cacheStoreMu sync.Mutex
+)
+
+// SetCacheStore replaces the store of cached responses.
+func SetCacheStore(store CacheStore) {
+	_cacheStoreMu.Lock()
+	defer _cacheStoreMu.Unlock()
+	_cacheStore = store
+}
+
+// getCacheStore returns the installed store, connecting the redis one on
+// first use. An unreachable redis is retried by the next request.
+func getCacheStore() (CacheStore, error) {
+	_cacheStoreMu.Lock()
+	defer _cacheStoreMu.Unlock()
+	if _cacheStore == nil {
+		client, err := redisClient()
+		if err != nil {
+			return nil, err
+		}
+		_cacheStore = NewRedisCacheStore(client)
+	}
+	return _cacheStore, nil
+}
+
+type redisCacheStore struct {
+	client redis.UniversalClient
+}
+
+// NewRedisCacheStore returns a CacheStore on client. Patterns are expanded
+// with SCAN, so deleting never blocks the server the way KEYS does.
+func NewRedisCacheStore(client redis.UniversalClient) CacheStore {
+	return &redisCacheStore{client: client}
+}
+
+func (this *redisCacheStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	bts, err := this.client.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return bts, true, nil
+}
+
+func (this *redisCacheStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return this.client.Set(ctx, key, value, ttl).Err()
+}
+
+func (this *redisCacheStore) Delete(ctx context.Context, patterns ...string) error {
+	keys := make([]string, 0)
+	for _, pattern := range patterns {
+		if !strings.ContainsAny(pattern, "*?[") {
+			keys = append(keys, pattern)
+			continue
+		}
+
+		iter := this.client.Scan(ctx, 0, pattern, 100).Iterator()
+		for iter.Next(ctx) {
+			keys = append(keys, iter.Val())
+		}
+		if err := iter.Err(); err != nil {
+			return err
+		}
+	}
+
+	for len(keys) > 0 {
+		n := len(keys)
+		if n > 100 {
+			n = 100
+		}
+		if err := this.client.Del(ctx, keys[:n]...).Err(); err != nil {
+			return err
+		}
+		keys = keys[n:]
+	}
+	return nil
+}
+
+type memoryCacheEntry struct {
+	value   []byte
+	expires time.Time
+}
+
+// MemoryCacheStore is a CacheStore held in process, for tests and single
+// instance servers.
+type MemoryCacheStore struct {
+	mu      sync.Mutex
+	entries map[string]memoryCacheEntry
+}
+
+func NewMemoryCacheStore() *MemoryCacheStore {
+	return &MemoryCacheStore{entries: make(map[string]memoryCacheEntry)}
+}
+
+func (this *MemoryCacheStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	entry, found := this.entries[key]
+	if !found {
+		return nil, false, nil
+	}
+	if !entry.expires.IsZero() && time.Now().After(entry.expires) {
+		delete(this.entries, key)
+		return nil, false, nil
+	}
+	return entry.value, true, nil
+}
+
+func (this *MemoryCacheStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	entry := memoryCacheEntry{value: value}
+	if ttl > 0 {
+		entry.expires = time.Now().Add(ttl)
+	}
+	this.entries[key] = entry
+	return nil
+}
+
+func (this *MemoryCacheStore) Delete(ctx context.Context, patterns ...string) error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+
+	for _, pattern := range patterns {
+		re, err := globRegexp(pattern)
+		if err != nil {
+			return err
+		}
+		for key := range this.entries {
+			if re.MatchString(key) {
+				delete(this.entries, key)
+			}
+		}
+	}
+	return nil
+}
+
+// globRegexp compiles a redis glob pattern: * and ? match any characters and
+// [...] a character class.
+func globRegexp(pattern string) (*regexp.Regexp, error) {
+	var expr strings.Builder
+	expr.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch ch := pattern[i]; ch {
+		case '*':
+			expr.WriteString(".*")
+		case '?':
+			expr.WriteString(".")
+		case '[':
+			end := strings.IndexByte(pattern[i:], ']')
+			if end < 0 {
+				expr.WriteString(`\[`)
+				continue
+			}
+			class := pattern[i+1 : i+end]
+			if strings.HasPrefix(class, "^") {
+				class = "^" + regexp.QuoteMeta(class[1:])
+			} else {
+				class = regexp.QuoteMeta(class)
+			}
+			expr.WriteString("[" + class + "]")
+			i += end
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+				expr.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+			}
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(ch)))
+		}
+	}
+	expr.WriteString("$")
+	return regexp.Compile(expr.String())
+}
+
+var cacheFlight singleflight.Group
+
+type cachedResponse struct {
+	response Response
+	ok       bool
+}
+
+// cacheId returns the cache key and lifetime of req, an empty key when its
+// response is not cached. Rendered responses are never cached, and without
+// a store the request skips the cache.
+func (this *handler) cacheId(req reflect.Value) (string, time.Duration) {
+	if this.isUpload || this.rsp.Implements(rendererType) {
+		return "", 0
+	}
+	setter, ok := req.Interface().(RequestCacheSetter)
+	if !ok {
+		return "", 0
+	}
+	key, ttl := setter.CacheId()
+	if key == "" {
+		return "", 0
+	}
+	if _, err := getCacheStore(); err != nil {
+		klog.Args("key", key, "err", err.Error()).Warn("Connect request cache failed")
+		return "", 0
+	}
+	return key, ttl
+}
+
+// cacheHit answers the request from the cache. A failing store is logged and
+// treated as a miss so the handler still serves the request.
+func (this *handler) cacheHit(c *gin.Context, key string) bool {
+	store, err := getCacheStore()
+	if err != nil {
+		klog.Args("key", key, "err", err.Error()).Warn("Get request cache failed")
+		return false
+	}
+	bts, found, err := store.Get(c.Request.Context(), key)
+	if err != nil {
+		klog.Args("key", key, "err", err.Error()).Warn("Get request cache failed")
+		return false
+	}
+	if !found {
+		return false
+	}
+
+	var data any
+	if err = jsoniter.Unmarshal(bts, &data); err != nil {
+		klog.Args("key", key, "err", err.Error()).Warn("Decode request cache failed")
+		return false
+	}
+
+	c.Header("X-Cache", "HIT")
+	writeResponse(c, Response{
+		Status: 0,
+		Msg:    "",
+		Data:   data,
+	})
+	c.Abort()
+	return true
+}
+
+// cacheTimeout bounds the store calls of a shared handler call, which run
+// detached from the request of any one caller.
+const cacheTimeout = 5 * time.Second
+
+// detachedContext keeps the values of a request context, such as its trace,
+// without its cancellation.
+type detachedContext struct {
+	context.Context
+}
+
+func (this detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (this detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (this detachedContext) Err() error {
+	return nil
+}
+
+// cachedCall runs the handler once for all concurrent misses of the same
+// request and stores its successful response. Handlers taking the
+// *gin.Context may read anything from it, so their calls are not shared.
+func (this *handler) cachedCall(c *gin.Context, req reflect.Value, key string, ttl time.Duration) (Response, bool) {
+	if this.inNum == 3 {
+		response, ok := this.call(c, req, nil)
+		if ok {
+			this.setCache(c.Request.Context(), key, response, ttl)
+		}
+		return response, ok
+	}
+
+	// requests sharing a cache id may still differ, so the call is shared
+	// by the requests of the same handler binding the same values.
+	flightKey := this.pkgPath + "." + this.handlerName + "." + this.methodName + "\n" + key
+	if bts, err := jsoniter.Marshal(req.Interface()); err == nil {
+		flightKey += "\n" + string(bts)
+	}
+
+	val, _, _ := cacheFlight.Do(flightKey, func() (any, error) {
+		ctx, cancel := context.WithTimeout(detachedContext{c.Request.Context()}, cacheTimeout)
+		defer cancel()
+
+		// a previous flight may have stored the response since the miss
+		if store, err := getCacheStore(); err == nil {
+			if bts, found, err := store.Get(ctx, key); err == nil && found {
+				var data any
+				if err = jsoniter.Unmarshal(bts, &data); err == nil {
+					return cachedResponse{response: Response{Data: data}, ok: true}, nil
+				}
+			}
+		}
+
+		response, ok := this.call(c, req, nil)
+		if ok {
+			this.setCache(ctx, key, response, ttl)
+		}
+		return cachedResponse{response: response, ok: ok}, nil
+	})
+	result := val.(cachedResponse)
+	return result.response, result.ok
+}
+
+// setCache stores a successful response, failures are only logged.
+func (this *handler) setCache(ctx context.Context, key string, response Response, ttl time.Duration) {
+	store, err := getCacheStore()
+	if err == nil {
+		var bts []byte
+		if bts, err = jsoniter.Marshal(response.Data); err == nil {
+			err = store.Set(ctx, key, bts, ttl)
+		}
+	}
+	if err != nil {
+		klog.Args("key", key, "err", err.Error()).Warn("Set request cache failed")
+	}
+}
+
+// deleteCache deletes the cache keys invalidated by req. The handler already
+// succeeded, so failures are only logged.
+func (this *handler) deleteCache(c *gin.Context, req reflect.Value) {
+	if this.isUpload {
+		return
+	}
+	deleter, ok := req.Interface().(RequestCacheDeleter)
+	if !ok {
+		return
+	}
+	patterns := deleter.DeleteCachePatterns()
+	if len(patterns) == 0 {
+		return
+	}
+	store, err := getCacheStore()
+	if err == nil {
+		err = store.Delete(c.Request.Context(), patterns...)
+	}
+	if err != nil {
+		klog.Args("patterns", strings.Join(patterns, ","), "err", err.Error()).Warn("Delete request cache failed")
+	}
+}
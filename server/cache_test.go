@@ -0,0 +1,161 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/xinzf/kit/container/kcfg"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type cacheRequest struct {
+	ID int `json:"id"`
+}
+
+func (this *cacheRequest) CacheId() (string, time.Duration) {
+	return fmt.Sprintf("item:%d", this.ID), time.Minute
+}
+
+type cacheDeleteRequest struct{}
+
+func (this *cacheDeleteRequest) DeleteCachePatterns() []string {
+	return []string{"item:*"}
+}
+
+type cacheResponse struct {
+	Calls int64 `json:"calls"`
+}
+
+type cacheHandler struct {
+	calls int64
+	// release is closed once every concurrent request missed the cache.
+	release chan struct{}
+}
+
+func (this *cacheHandler) Get(req *cacheRequest, rsp *cacheResponse) error {
+	if this.release != nil {
+		<-this.release
+	}
+	rsp.Calls = atomic.AddInt64(&this.calls, 1)
+	return nil
+}
+
+// countingCacheStore closes missed once it answered want misses.
+type countingCacheStore struct {
+	*MemoryCacheStore
+	misses int64
+	want   int64
+	missed chan struct{}
+}
+
+func (this *countingCacheStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	value, found, err := this.MemoryCacheStore.Get(ctx, key)
+	if !found && atomic.AddInt64(&this.misses, 1) == this.want {
+		close(this.missed)
+	}
+	return value, found, err
+}
+
+func (this *cacheHandler) Flush(req *cacheDeleteRequest, rsp *cacheResponse) error {
+	return nil
+}
+
+func TestMemoryCacheStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryCacheStore()
+	for _, key := range []string{"user:1", "user:2", "users", "item:1"} {
+		_ = store.Set(ctx, key, []byte(key), time.Minute)
+	}
+	_ = store.Set(ctx, "expired", []byte("x"), time.Nanosecond)
+	time.Sleep(time.Millisecond)
+
+	if err := store.Delete(ctx, "user:*", "item:[12]"); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]bool{"user:1": false, "user:2": false, "users": true, "item:1": false, "expired": false} {
+		if _, found, _ := store.Get(ctx, key); found != want {
+			t.Errorf("Get(%q) found = %v, want %v", key, found, want)
+		}
+	}
+}
+
+func TestRequestCache(t *testing.T) {
+	const concurrency = 10
+	store := &countingCacheStore{MemoryCacheStore: NewMemoryCacheStore(), want: concurrency, missed: make(chan struct{})}
+	SetCacheStore(store)
+	defer SetCacheStore(nil)
+
+	h := &cacheHandler{release: store.missed}
+	g := gin.New()
+	for _, name := range []string{"Get", "Flush"} {
+		method := reflect.ValueOf(h).MethodByName(name)
+		g.POST("/"+name, newHandler("", "cacheHandler", name, method, "", nil).handlerFunc)
+	}
+	do := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{"id":1}`))
+		r.Header.Set("Content-Type", "application/json")
+		g.ServeHTTP(w, r)
+		return w
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if w := do("/Get"); w.Header().Get("X-Cache") == "" {
+				t.Errorf("missing X-Cache header")
+			}
+		}()
+	}
+	wg.Wait()
+	if calls := atomic.LoadInt64(&h.calls); calls != 1 {
+		t.Fatalf("handler called %d times, want 1", calls)
+	}
+
+	w := do("/Get")
+	if w.Header().Get("X-Cache") != "HIT" || !strings.Contains(w.Body.String(), `"calls":1`) {
+		t.Fatalf("cached response = %s %s", w.Header().Get("X-Cache"), w.Body.String())
+	}
+
+	do("/Flush")
+	w = do("/Get")
+	if w.Header().Get("X-Cache") != "MISS" || !strings.Contains(w.Body.String(), `"calls":2`) {
+		t.Fatalf("response after flush = %s %s", w.Header().Get("X-Cache"), w.Body.String())
+	}
+}
+
+func TestRequestCacheUnreachable(t *testing.T) {
+	host := kcfg.Get[string]("cache.host")
+	kcfg.Set("cache.host", "127.0.0.1:1")
+	defer kcfg.Set("cache.host", host)
+	SetCacheStore(nil)
+	defer SetCacheStore(nil)
+
+	h := &cacheHandler{}
+	g := gin.New()
+	for _, name := range []string{"Get", "Flush"} {
+		method := reflect.ValueOf(h).MethodByName(name)
+		g.POST("/"+name, newHandler("", "cacheHandler", name, method, "", nil).handlerFunc)
+	}
+	for i, path := range []string{"/Get", "/Get", "/Flush"} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{"id":1}`))
+		r.Header.Set("Content-Type", "application/json")
+		g.ServeHTTP(w, r)
+		if w.Code != http.StatusOK || w.Header().Get("X-Cache") == "HIT" {
+			t.Errorf("request %d to %s without redis = %d %s %s", i, path, w.Code, w.Header().Get("X-Cache"), w.Body.String())
+		}
+	}
+	if calls := atomic.LoadInt64(&h.calls); calls != 2 {
+		t.Errorf("handler called %d times, want 2", calls)
+	}
+}
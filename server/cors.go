@@ -0,0 +1,161 @@
+package server
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/xinzf/kit/container/kcfg"
+	"github.com/xinzf/kit/container/kvar"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// CORSConfig configures the CORS middleware.
+type CORSConfig struct {
+	// AllowOrigins lists the allowed origins. "*" allows any origin and a
+	// single leading wildcard matches subdomains: https://*.example.com.
+	AllowOrigins []string
+	// AllowMethods defaults to GET, POST, PUT, PATCH, DELETE and HEAD.
+	AllowMethods []string
+	// AllowHeaders defaults to the headers asked for by the preflight.
+	AllowHeaders  []string
+	ExposeHeaders []string
+	// AllowCredentials lets the listed origins send cookies and auth
+	// headers. It cannot be combined with "*", which would hand the
+	// credentials of a user to any site.
+	AllowCredentials bool
+	// MaxAge is how long browsers may cache a preflight, 0 leaves it to them.
+	MaxAge time.Duration
+}
+
+var defaultCORSMethods = []string{
+	http.MethodGet,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+	http.MethodHead,
+}
+
+// corsConfig reads a cors section such as server.cors. It returns false when
+// no origin is allowed, in which case no CORS middleware is installed.
+func corsConfig(key string) (CORSConfig, bool) {
+	cfg := CORSConfig{}
+	for name, val := range kvar.New(kcfg.Get[any](key)).MapVar() {
+		switch strings.ToLower(name) {
+		case "alloworigins", "origins":
+			cfg.AllowOrigins = val.Strings()
+		case "allowmethods", "methods":
+			cfg.AllowMethods = val.Strings()
+		case "allowheaders", "headers":
+			cfg.AllowHeaders = val.Strings()
+		case "exposeheaders":
+			cfg.ExposeHeaders = val.Strings()
+		case "allowcredentials", "credentials":
+			cfg.AllowCredentials = val.Bool()
+		case "maxage":
+			cfg.MaxAge = val.Duration()
+		}
+	}
+	return cfg, len(cfg.AllowOrigins) > 0
+}
+
+// CORS answers preflight requests and adds the Access-Control-* headers to
+// the responses of allowed origins. Preflights from other origins are
+// rejected with 403, their other requests are served without CORS headers
+// and so are blocked by the browser. It panics when AllowOrigins holds "*"
+// and AllowCredentials is set.
+func CORS(cfg CORSConfig) gin.HandlerFunc {
+	if len(cfg.AllowMethods) == 0 {
+		cfg.AllowMethods = defaultCORSMethods
+	}
+	allowAny := false
+	for _, origin := range cfg.AllowOrigins {
+		if origin == "*" {
+			allowAny = true
+		}
+	}
+	if allowAny && cfg.AllowCredentials {
+		panic(any(`cors: AllowCredentials cannot be used with the "*" origin, list the allowed origins instead`))
+	}
+
+	allowed := func(origin string) bool {
+		if allowAny {
+			return true
+		}
+		for _, pattern := range cfg.AllowOrigins {
+			if strings.EqualFold(pattern, origin) {
+				return true
+			}
+			if idx := strings.Index(pattern, "*"); idx >= 0 {
+				prefix, suffix := pattern[:idx], pattern[idx+1:]
+				if len(origin) > len(prefix)+len(suffix) &&
+					strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	allowMethods := strings.Join(cfg.AllowMethods, ", ")
+	allowHeaders := strings.Join(cfg.AllowHeaders, ", ")
+	exposeHeaders := strings.Join(cfg.ExposeHeaders, ", ")
+	maxAge := ""
+	if cfg.MaxAge > 0 {
+		maxAge = fmt.Sprintf("%d", int(cfg.MaxAge/time.Second))
+	}
+
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin == "" {
+			c.Next()
+			return
+		}
+
+		header := c.Writer.Header()
+		header.Add("Vary", "Origin")
+		preflight := c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != ""
+		if preflight {
+			header.Add("Vary", "Access-Control-Request-Method")
+			header.Add("Vary", "Access-Control-Request-Headers")
+		}
+
+		if !allowed(origin) {
+			if preflight {
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+			c.Next()
+			return
+		}
+
+		if allowAny {
+			header.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			header.Set("Access-Control-Allow-Origin", origin)
+		}
+		if cfg.AllowCredentials {
+			header.Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if !preflight {
+			if exposeHeaders != "" {
+				header.Set("Access-Control-Expose-Headers", exposeHeaders)
+			}
+			c.Next()
+			return
+		}
+
+		header.Set("Access-Control-Allow-Methods", allowMethods)
+		if allowHeaders != "" {
+			header.Set("Access-Control-Allow-Headers", allowHeaders)
+		} else if requested := c.GetHeader("Access-Control-Request-Headers"); requested != "" {
+			header.Set("Access-Control-Allow-Headers", requested)
+		}
+		if maxAge != "" {
+			header.Set("Access-Control-Max-Age", maxAge)
+		}
+		c.AbortWithStatus(http.StatusNoContent)
+	}
+}
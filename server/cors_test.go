@@ -0,0 +1,150 @@
+package server
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func corsEngine(cfg CORSConfig) *gin.Engine {
+	g := gin.New()
+	g.Use(CORS(cfg))
+	g.GET("/users", func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+	return g
+}
+
+func corsRequest(g *gin.Engine, method, origin string, header map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, "/users", nil)
+	if origin != "" {
+		r.Header.Set("Origin", origin)
+	}
+	for name, value := range header {
+		r.Header.Set(name, value)
+	}
+	w := httptest.NewRecorder()
+	g.ServeHTTP(w, r)
+	return w
+}
+
+func TestCORSPreflight(t *testing.T) {
+	g := corsEngine(CORSConfig{
+		AllowOrigins: []string{"https://app.example.com"},
+		MaxAge:       10 * time.Minute,
+	})
+	w := corsRequest(g, http.MethodOptions, "https://app.example.com", map[string]string{
+		"Access-Control-Request-Method":  http.MethodPut,
+		"Access-Control-Request-Headers": "X-Token",
+	})
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("preflight = %d, want 204", w.Code)
+	}
+	expect := map[string]string{
+		"Access-Control-Allow-Origin":  "https://app.example.com",
+		"Access-Control-Allow-Methods": "GET, POST, PUT, PATCH, DELETE, HEAD",
+		"Access-Control-Allow-Headers": "X-Token",
+		"Access-Control-Max-Age":       "600",
+	}
+	for name, value := range expect {
+		if got := w.Header().Get(name); got != value {
+			t.Errorf("%s = %q, want %q", name, got, value)
+		}
+	}
+	if vary := w.Header().Values("Vary"); len(vary) != 3 {
+		t.Errorf("Vary = %v", vary)
+	}
+
+	g = corsEngine(CORSConfig{AllowOrigins: []string{"*"}, AllowMethods: []string{"GET"}, AllowHeaders: []string{"Authorization"}})
+	w = corsRequest(g, http.MethodOptions, "https://any.example.org", map[string]string{
+		"Access-Control-Request-Method":  http.MethodGet,
+		"Access-Control-Request-Headers": "X-Token",
+	})
+	if w.Header().Get("Access-Control-Allow-Methods") != "GET" || w.Header().Get("Access-Control-Allow-Headers") != "Authorization" {
+		t.Errorf("configured preflight headers = %v", w.Header())
+	}
+}
+
+func TestCORSOrigins(t *testing.T) {
+	tests := []struct {
+		name    string
+		origins []string
+		origin  string
+		allow   string
+	}{
+		{"exact", []string{"https://app.example.com"}, "https://app.example.com", "https://app.example.com"},
+		{"case insensitive", []string{"https://App.Example.com"}, "https://app.example.com", "https://app.example.com"},
+		{"other origin", []string{"https://app.example.com"}, "https://evil.com", ""},
+		{"subdomain wildcard", []string{"https://*.example.com"}, "https://api.example.com", "https://api.example.com"},
+		{"wildcard needs a subdomain", []string{"https://*.example.com"}, "https://.example.com", ""},
+		{"wildcard other domain", []string{"https://*.example.com"}, "https://example.com.evil.com", ""},
+		{"wildcard other scheme", []string{"https://*.example.com"}, "http://api.example.com", ""},
+		{"any", []string{"*"}, "https://evil.com", "*"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := corsRequest(corsEngine(CORSConfig{AllowOrigins: tt.origins}), http.MethodGet, tt.origin, nil)
+			if w.Code != http.StatusOK {
+				t.Errorf("code = %d, want 200", w.Code)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.allow {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.allow)
+			}
+		})
+	}
+}
+
+func TestCORSCredentials(t *testing.T) {
+	g := corsEngine(CORSConfig{AllowOrigins: []string{"https://*.example.com"}, AllowCredentials: true, ExposeHeaders: []string{"X-Total"}})
+	w := corsRequest(g, http.MethodGet, "https://app.example.com", nil)
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "https://app.example.com" {
+		t.Errorf("Access-Control-Allow-Origin with credentials = %q, want the origin", got)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Credentials"); got != "true" {
+		t.Errorf("Access-Control-Allow-Credentials = %q, want true", got)
+	}
+	if got := w.Header().Get("Access-Control-Expose-Headers"); got != "X-Total" {
+		t.Errorf("Access-Control-Expose-Headers = %q, want X-Total", got)
+	}
+
+	w = corsRequest(g, http.MethodGet, "https://evil.com", nil)
+	if w.Header().Get("Access-Control-Allow-Origin") != "" || w.Header().Get("Access-Control-Allow-Credentials") != "" {
+		t.Errorf("credentials for another origin = %v", w.Header())
+	}
+
+	g = corsEngine(CORSConfig{AllowOrigins: []string{"https://app.example.com"}})
+	w = corsRequest(g, http.MethodGet, "https://app.example.com", nil)
+	if got := w.Header().Get("Access-Control-Allow-Credentials"); got != "" {
+		t.Errorf("Access-Control-Allow-Credentials without credentials = %q", got)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("CORS() with credentials for any origin did not panic")
+		}
+	}()
+	CORS(CORSConfig{AllowOrigins: []string{"*"}, AllowCredentials: true})
+}
+
+func TestCORSRejected(t *testing.T) {
+	g := corsEngine(CORSConfig{AllowOrigins: []string{"https://app.example.com"}})
+	w := corsRequest(g, http.MethodOptions, "https://evil.com", map[string]string{"Access-Control-Request-Method": http.MethodGet})
+	if w.Code != http.StatusForbidden {
+		t.Errorf("rejected preflight = %d, want 403", w.Code)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("rejected preflight Access-Control-Allow-Origin = %q", got)
+	}
+
+	w = corsRequest(g, http.MethodGet, "https://evil.com", nil)
+	if w.Code != http.StatusOK || w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("rejected request = %d %v, want 200 without CORS headers", w.Code, w.Header())
+	}
+
+	w = corsRequest(g, http.MethodGet, "", nil)
+	if w.Code != http.StatusOK || len(w.Header().Values("Vary")) != 0 {
+		t.Errorf("same origin request = %d %v", w.Code, w.Header())
+	}
+}
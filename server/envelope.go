@@ -0,0 +1,74 @@
+package server
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+// ResponseFactory builds the JSON body of every handler response, failed or
+// not, from its status, message and data.
+type ResponseFactory func(status int, msg string, data any) ResponseInterface
+
+// StatusMapper returns the HTTP status code sent with a response status.
+type StatusMapper func(status int) int
+
+func defaultResponseFactory(status int, msg string, data any) ResponseInterface {
+	return Response{Status: status, Msg: msg, Data: data}
+}
+
+// SetResponseFactory replaces the {status, msg, data} envelope of the
+// default App. Nil restores it.
+func SetResponseFactory(factory ResponseFactory) {
+	defaultApp.responseFactory = factory
+}
+
+// SetStatusMapper sets how response statuses of the default App become HTTP
+// status codes. Without one every response is sent with 200, or mapped by
+// HTTPStatus when server.httpStatus is on.
+func SetStatusMapper(mapper StatusMapper) {
+	defaultApp.statusMapper = mapper
+}
+
+// HTTPStatus sends statuses that are HTTP error codes as they are, other
+// non-zero statuses as 500 and success as 200.
+func HTTPStatus(status int) int {
+	switch {
+	case status == 0:
+		return http.StatusOK
+	case status >= 400 && status <= 599:
+		return status
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func (this *App) httpStatus(status int) int {
+	if this.statusMapper != nil {
+		return this.statusMapper(status)
+	}
+	if this.Config().HTTPStatus {
+		return HTTPStatus(status)
+	}
+	return http.StatusOK
+}
+
+// writeResponse encodes response through the response factory of the App
+// serving c, with its mapped HTTP status. Failed responses abort the
+// remaining handlers.
+func writeResponse(c *gin.Context, response Response) ResponseInterface {
+	if response.Data == nil {
+		response.Data = map[string]interface{}{}
+	}
+	app := appOf(c)
+	factory := app.responseFactory
+	if factory == nil {
+		factory = defaultResponseFactory
+	}
+	body := factory(response.Status, response.Msg, response.Data)
+	if response.Status != 0 {
+		c.AbortWithStatusJSON(app.httpStatus(response.Status), body)
+	} else {
+		c.JSON(app.httpStatus(response.Status), body)
+	}
+	return body
+}
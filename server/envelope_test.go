@@ -0,0 +1,69 @@
+package server
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/xinzf/kit/kerrors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+type envelopeRequest struct{}
+
+type envelopeHandler struct{}
+
+func (this *envelopeHandler) Missing(req *envelopeRequest, rsp *map[string]any) error {
+	return kerrors.New(404, "user not found").WithDetail("id", 7)
+}
+
+func (this *envelopeHandler) Bare(c *gin.Context) error {
+	return errors.New("boom")
+}
+
+type apiResponse struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Result  any    `json:"result"`
+}
+
+func (r apiResponse) GetData() any       { return r.Result }
+func (r apiResponse) GetStatus() int     { return r.Code }
+func (r apiResponse) GetMessage() string { return r.Message }
+
+func TestResponseEnvelope(t *testing.T) {
+	SetResponseFactory(func(status int, msg string, data any) ResponseInterface {
+		return apiResponse{Code: status, Message: msg, Result: data}
+	})
+	SetStatusMapper(HTTPStatus)
+	defer func() {
+		SetResponseFactory(func(status int, msg string, data any) ResponseInterface {
+			return Response{Status: status, Msg: msg, Data: data}
+		})
+		SetStatusMapper(nil)
+	}()
+
+	h := &envelopeHandler{}
+	g := gin.New()
+	for _, name := range []string{"Missing", "Bare"} {
+		method := reflect.ValueOf(h).MethodByName(name)
+		g.GET("/"+name, newHandler("", "envelopeHandler", name, method, "", nil).handlerFunc)
+	}
+
+	tests := []struct {
+		path string
+		code int
+		body string
+	}{
+		{"/Missing", 404, `{"code":404,"message":"user not found","result":{"id":7}}`},
+		{"/Bare", 500, `{"code":500,"message":"boom","result":{}}`},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		g.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if w.Code != tt.code || w.Body.String() != tt.body {
+			t.Errorf("GET %s = %d %s, want %d %s", tt.path, w.Code, w.Body.String(), tt.code, tt.body)
+		}
+	}
+}
@@ -1,15 +1,60 @@
 package server
 
 import (
+	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
+	"github.com/xinzf/kit/kerrors"
 	"github.com/xinzf/kit/klog"
+	"net/http"
 	"reflect"
-	"time"
+	"strings"
+	"unicode"
 )
 
+// errNotHandler marks methods without arguments, such as the Paths or
+// HandlerName protocol methods, which are never routed.
+var errNotHandler = errors.New("not a handler")
+
+// checkSignature reports why a method of type fun cannot be routed. Handlers
+// take a *gin.Context, a request and a response pointer, or a context
+// followed by both, and return an error, optionally after an int status.
+func checkSignature(fun reflect.Type) error {
+	if fun.NumIn() == 0 {
+		return errNotHandler
+	}
+	if fun.NumIn() > 3 {
+		return fmt.Errorf("takes %d arguments, want at most 3", fun.NumIn())
+	}
+	if fun.NumOut() < 1 || fun.NumOut() > 2 {
+		return fmt.Errorf("returns %d values, want error or (int, error)", fun.NumOut())
+	}
+
+	if fun.NumIn() != 2 && fun.In(0).String() != "*gin.Context" {
+		return errors.New("the context argument is not *gin.Context")
+	}
+	if fun.NumIn() > 1 {
+		req, rsp := fun.In(fun.NumIn()-2), fun.In(fun.NumIn()-1)
+		if req.Kind() != reflect.Interface && req.Kind() != reflect.Ptr {
+			return errors.New("the request argument is not a pointer")
+		}
+		if rsp.Kind() != reflect.Interface && rsp.Kind() != reflect.Map && rsp.Kind() != reflect.Ptr {
+			return errors.New("the response argument is not a pointer")
+		}
+	}
+
+	if fun.NumOut() == 2 && fun.Out(0).String() != "int" {
+		return errors.New("the output code is not an int")
+	}
+	if fun.Out(fun.NumOut()-1).String() != "error" {
+		return errors.New("the output error is not error type")
+	}
+	return nil
+}
+
+// newHandler returns nil for methods checkSignature rejects.
 func newHandler(pkgPath, handlerName, methodName string, fun reflect.Value, aliasName string, paths map[string][]string) (hdl *handler) {
-	if fun.Type().NumIn() > 3 || fun.Type().NumIn() < 1 || fun.Type().NumOut() < 1 || fun.Type().NumOut() > 2 {
+	if checkSignature(fun.Type()) != nil {
 		return
 	}
 
@@ -22,60 +67,23 @@ func newHandler(pkgPath, handlerName, methodName string, fun reflect.Value, alia
 		isUpload   bool
 	)
 
-	if fun.Type().NumIn() == 1 {
+	switch fun.Type().NumIn() {
+	case 1:
 		c = fun.Type().In(0)
-	} else if fun.Type().NumIn() == 2 {
+	case 2:
 		req = fun.Type().In(0)
 		rsp = fun.Type().In(1)
-	} else if fun.Type().NumIn() == 3 {
+	case 3:
 		c = fun.Type().In(0)
 		req = fun.Type().In(1)
 		rsp = fun.Type().In(2)
-	} else {
-		return nil
 	}
 
 	if fun.Type().NumOut() == 2 {
 		outputCode = fun.Type().Out(0)
 		outputErr = fun.Type().Out(1)
-	} else if fun.Type().NumOut() == 1 {
-		outputErr = fun.Type().Out(0)
 	} else {
-		return nil
-	}
-
-	if fun.Type().NumIn() == 1 {
-		if c.String() != "*gin.Context" {
-			klog.Warnf("the context argument of %s.%s is not *gin.Context", handlerName, methodName)
-			return
-		}
-	} else if fun.Type().NumIn() == 2 {
-		if req.Kind() != reflect.Interface && req.Kind() != reflect.Ptr {
-			klog.Warnf("the request argument of %s.%s is not a pointer", handlerName, methodName)
-			return
-		}
-
-		if rsp.Kind() != reflect.Interface && rsp.Kind() != reflect.Map {
-			if rsp.Kind() != reflect.Ptr {
-				klog.Warnf("the response argument of %s.%s is not a pointer", handlerName, methodName)
-				return
-			}
-		}
-	} else if fun.Type().NumIn() == 3 {
-		if c.String() != "*gin.Context" {
-			klog.Warnf("the context argument of %s.%s is not *gin.Context", handlerName, methodName)
-			return
-		}
-	}
-
-	if fun.Type().NumOut() == 2 && outputCode.String() != "int" {
-		klog.Warnf("the output code of %s.%s is not a int", handlerName, methodName)
-		return
-	}
-
-	if outputErr.String() != "error" {
-		klog.Warnf("the output error of %s.%s is not error type", handlerName, methodName)
-		return
+		outputErr = fun.Type().Out(0)
 	}
 
 	if fun.Type().NumIn() > 1 {
@@ -120,11 +128,9 @@ type handler struct {
 	outputCode  reflect.Type
 	outputErr   reflect.Type
 	paths       map[string][]string
+	verbs       []string
+	description string
 	isUpload    bool
-	cache       struct {
-		key      string
-		duration time.Duration
-	}
 }
 
 func (this *handler) getBindPath(paths map[string][]string) string {
@@ -164,23 +170,24 @@ func (this *handler) handlerFunc(c *gin.Context) {
 		}
 
 		if err != nil && code == 0 {
-			code = 500
+			code = kerrors.Code(err, 500)
 		}
 
 		if code != 0 || err != nil {
 			if err == nil {
-				err = fmt.Errorf("Error with: %d\n", code)
+				err = fmt.Errorf("Error with: %d", code)
+			}
+			_ = c.Error(err)
+			// the handler may have answered the request itself
+			if c.Writer.Written() {
+				c.Abort()
+				return
 			}
-			_ = c.AbortWithError(200, err)
+			writeResponse(c, errorResponse(code, err))
 			return
 		}
 	} else {
 		var response Response
-		defer func() {
-			if response.Data == nil {
-				response.Data = map[string]interface{}{}
-			}
-		}()
 
 		var req reflect.Value
 		if this.req.Kind() == reflect.Interface {
@@ -191,24 +198,43 @@ func (this *handler) handlerFunc(c *gin.Context) {
 
 		var uploadFile *UploadRequest
 		if this.isUpload == false {
-			contentType := c.ContentType()
-			if contentType == "text/xml" {
-				if err := c.BindXML(req.Interface()); err != nil {
-					response.Status = 400
-					response.Msg = err.Error()
-					c.AbortWithStatusJSON(200, response)
-					return
-				}
-			} else if contentType == "application/json" {
-				if err := c.BindJSON(req.Interface()); err != nil {
+			if err := bindRequest(c, req.Interface()); err != nil {
+				response.Status = 400
+				response.Msg = err.Error()
+				writeResponse(c, response)
+				return
+			}
+			if err := getValidator().validate(c, req.Interface()); err != nil {
+				var validationErr *ValidationError
+				if errors.As(err, &validationErr) {
 					response.Status = 400
-					response.Msg = err.Error()
-					c.AbortWithStatusJSON(200, response)
-					return
+					response.Data = map[string]interface{}{"errors": validationErr.Errors}
+				} else {
+					response.Status = kerrors.Code(err, 400)
 				}
+				response.Msg = err.Error()
+				writeResponse(c, response)
+				return
 			}
 		} else {
 			uploadFile = &UploadRequest{ctx: c}
+			defer uploadFile.removeTempFiles()
+			var err error
+			if action := c.GetHeader(UploadActionHeader); action != "" {
+				var answered bool
+				if answered, err = uploadFile.resume(action); answered {
+					return
+				}
+			} else {
+				err = uploadFile.parse(appOf(c).Config().Upload)
+			}
+			if err != nil {
+				writeResponse(c, errorResponse(kerrors.Code(err, 400), err))
+				return
+			}
+			if uploadFile.chunked != nil {
+				defer uploadFile.settle()
+			}
 		}
 
 		this.afterRequest(req, c)
@@ -216,73 +242,124 @@ func (this *handler) handlerFunc(c *gin.Context) {
 			return
 		}
 
-		var rsp reflect.Value
-		if this.rsp.String() == "interface {}" {
-			rsp = reflect.ValueOf(map[string]any{})
-		} else {
-			rsp = reflect.New(this.rsp.Elem())
-		}
-
-		values := make([]reflect.Value, 0)
-		if this.inNum == 2 {
-			if this.isUpload {
-				values = this.fun.Call([]reflect.Value{
-					reflect.ValueOf(uploadFile),
-					rsp,
-				})
-			} else {
-				values = this.fun.Call([]reflect.Value{
-					req, rsp,
-				})
+		var ok bool
+		if key, ttl := this.cacheId(req); key != "" {
+			if this.cacheHit(c, key) {
+				return
 			}
+			c.Header("X-Cache", "MISS")
+			response, ok = this.cachedCall(c, req, key, ttl)
 		} else {
-			if this.isUpload {
-				values = this.fun.Call([]reflect.Value{
-					reflect.ValueOf(c),
-					reflect.ValueOf(uploadFile),
-					rsp,
-				})
-			} else {
-				values = this.fun.Call([]reflect.Value{
-					reflect.ValueOf(c),
-					req, rsp,
-				})
-			}
+			response, ok = this.call(c, req, uploadFile)
 		}
-
-		var (
-			code int = 0
-			err  error
-		)
-		if this.outNum == 2 {
-			code, _ = values[0].Interface().(int)
-			err, _ = values[1].Interface().(error)
-		} else {
-			err, _ = values[0].Interface().(error)
+		if !ok {
+			writeResponse(c, response)
+			return
 		}
 
-		if err != nil && code == 0 {
-			code = 500
+		this.deleteCache(c, req)
+		if uploadFile != nil {
+			uploadFile.completed = true
 		}
-
-		if code != 0 || err != nil {
-			response.Status = code
-			response.Msg = err.Error()
-			c.AbortWithStatusJSON(200, response)
+		if renderer, isRenderer := response.Data.(ResponseRenderer); isRenderer {
+			this.render(c, renderer)
+			this.afterResponse(req, c, response)
 			return
 		}
+		this.afterResponse(req, c, writeResponse(c, response))
+	}
+	return
+}
+
+// call runs the handler method, ok is false when it returned an error.
+func (this *handler) call(c *gin.Context, req reflect.Value, uploadFile *UploadRequest) (response Response, ok bool) {
+	var rsp reflect.Value
+	if this.rsp.String() == "interface {}" {
+		rsp = reflect.ValueOf(map[string]any{})
+	} else {
+		rsp = reflect.New(this.rsp.Elem())
+	}
 
-		response.Data = rsp.Interface()
-		output := Response{
-			Status: 0,
-			Msg:    "",
-			Data:   response.Data,
+	values := make([]reflect.Value, 0)
+	if this.inNum == 2 {
+		if this.isUpload {
+			values = this.fun.Call([]reflect.Value{
+				reflect.ValueOf(uploadFile),
+				rsp,
+			})
+		} else {
+			values = this.fun.Call([]reflect.Value{
+				req, rsp,
+			})
 		}
+	} else {
+		if this.isUpload {
+			values = this.fun.Call([]reflect.Value{
+				reflect.ValueOf(c),
+				reflect.ValueOf(uploadFile),
+				rsp,
+			})
+		} else {
+			values = this.fun.Call([]reflect.Value{
+				reflect.ValueOf(c),
+				req, rsp,
+			})
+		}
+	}
 
-		c.JSON(200, output)
-		this.afterResponse(req, c, output)
+	var (
+		code int = 0
+		err  error
+	)
+	if this.outNum == 2 {
+		code, _ = values[0].Interface().(int)
+		err, _ = values[1].Interface().(error)
+	} else {
+		err, _ = values[0].Interface().(error)
 	}
-	return
+
+	if err != nil && code == 0 {
+		code = kerrors.Code(err, 500)
+	}
+
+	if code != 0 || err != nil {
+		return errorResponse(code, err), false
+	}
+
+	return Response{
+		Status: 0,
+		Msg:    "",
+		Data:   rsp.Interface(),
+	}, true
+}
+
+// render writes a ResponseRenderer. Errors before anything was written are
+// answered with a JSON Response, later ones can only be logged.
+func (this *handler) render(c *gin.Context, renderer ResponseRenderer) {
+	err := renderer.Render(c)
+	if err == nil {
+		return
+	}
+	if c.Writer.Written() {
+		klog.Args("handler", this.handlerName, "method", this.methodName, "err", err.Error()).Warn("Render response failed")
+		return
+	}
+	c.Header("Content-Type", "")
+	c.Header("Content-Disposition", "")
+	writeResponse(c, errorResponse(kerrors.Code(err, 500), err))
+}
+
+// errorResponse is the response of a handler failing with code and err,
+// carrying the details of a kerrors.Error as data.
+func errorResponse(code int, err error) Response {
+	response := Response{Status: code, Data: map[string]interface{}{}}
+	if err != nil {
+		response.Msg = err.Error()
+	}
+	if e, found := kerrors.FromError(err); found && len(e.Details) > 0 {
+		response.Data = e.Details
+	}
+	return response
 }
 
 func (this *handler) afterRequest(reqVal reflect.Value, c *gin.Context) {
@@ -329,104 +406,36 @@ func (this *handler) afterResponse(reqVal reflect.Value, c *gin.Context, rsp Res
 	fn.Call([]reflect.Value{reflect.ValueOf(c), reflect.ValueOf(rsp)})
 }
 
-//
-//func (this *handler) requestCache(reqVal reflect.Value, c *gin.Context) {
-//	if this.isUpload {
-//		return
-//	}
-//
-//	if _, found := this.req.MethodByName("CacheId"); !found {
-//		return
-//	}
-//	initFunc := reqVal.MethodByName("CacheId")
-//	if initFunc.Type().NumOut() != 2 {
-//		return
-//	}
-//	if initFunc.Type().Out(0).Kind() != reflect.String || initFunc.Type().Out(1).Kind() != reflect.Int64 {
-//		return
-//	}
-//
-//	values := initFunc.Call([]reflect.Value{})
-//	this.cache.key = values[0].Interface().(string)
-//	this.cache.duration = values[1].Interface().(time.Duration)
-//	if this.cache.key == "" {
-//		return
-//	}
-//
-//	hit := false
-//	bts, err := cache.Redis().Get(context.TODO(), this.cache.key).Bytes()
-//	if err != nil {
-//		if err.Error() == redis.Nil.Error() {
-//			err = nil
-//		}
-//	} else {
-//		hit = true
-//	}
-//
-//	if err != nil {
-//		c.AbortWithStatusJSON(200, Response{
-//			Status: 500,
-//			Msg:    fmt.Sprintf("get request cache failed: %s", err.Error()),
-//			Data:   map[string]interface{}{},
-//		})
-//		return
-//	}
-//
-//	if !hit {
-//		return
-//	}
-//
-//	var data any
-//	err = jsoniter.Unmarshal(bts, &data)
-//	if err != nil {
-//		klog.Errof("get request cache failed: %s", err.Error())
-//		return
-//	}
-//	c.AbortWithStatusJSON(200, Response{
-//		Status: 0,
-//		Msg:    "",
-//		Data:   data,
-//	})
-//	klog.Debugf("hit request cache: %s", this.cache.key)
-//}
-//
-//func (this *handler) deleteCache(reqVal reflect.Value) error {
-//	if this.isUpload {
-//		return nil
-//	}
-//
-//	if _, found := this.req.MethodByName("DeleteCachePatterns"); !found {
-//		return nil
-//	}
-//	initFunc := reqVal.MethodByName("DeleteCachePatterns")
-//	if initFunc.Type().NumOut() != 1 {
-//		return nil
-//	}
-//	if initFunc.Type().Out(0).Kind() != reflect.Slice {
-//		return nil
-//	}
-//
-//	values := initFunc.Call([]reflect.Value{})
-//	if values[0].Interface() == nil {
-//		return nil
-//	}
-//
-//	patterns := kvar.New(values[0].Interface()).Strings()
-//	if len(patterns) == 0 {
-//		return nil
-//	}
-//
-//	keys := make([]string, 0)
-//	for _, s := range patterns {
-//		if strings.Contains(s, "*") {
-//			keys = append(keys, cache.Redis().Keys(context.TODO(), s).Val()...)
-//		} else {
-//			keys = append(keys, s)
-//		}
-//	}
-//
-//	if len(keys) == 0 {
-//		return nil
-//	}
-//	return cache.Redis().Del(context.TODO(), keys...).Err()
-//}
+var defaultVerbs = []string{http.MethodGet, http.MethodPost}
+
+var prefixVerbs = []string{
+	http.MethodGet,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+}
+
+// getVerbs returns the HTTP verbs the handler answers: the ones declared by
+// HandlerMethods, else the one its method name starts with (GetUser,
+// DeleteUser) when methodPrefix is on, else GET and POST.
+func (this *handler) getVerbs(methodPrefix bool) []string {
+	if len(this.verbs) > 0 {
+		verbs := make([]string, 0, len(this.verbs))
+		for _, verb := range this.verbs {
+			verbs = append(verbs, strings.ToUpper(verb))
+		}
+		return verbs
+	}
+
+	if methodPrefix {
+		for _, verb := range prefixVerbs {
+			prefix := verb[:1] + strings.ToLower(verb[1:])
+			if len(this.methodName) > len(prefix) && strings.HasPrefix(this.methodName, prefix) &&
+				unicode.IsUpper(rune(this.methodName[len(prefix)])) {
+				return []string{verb}
+			}
+		}
+	}
+	return defaultVerbs
+}
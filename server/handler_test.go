@@ -0,0 +1,76 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+type verbHandler struct{}
+
+func (this *verbHandler) Methods() map[string][]string {
+	return map[string][]string{"Sync": {"put", "patch"}}
+}
+
+func (this *verbHandler) GetUser(req *struct{}, rsp *map[string]any) error    { return nil }
+func (this *verbHandler) DeleteUser(req *struct{}, rsp *map[string]any) error { return nil }
+func (this *verbHandler) Sync(req *struct{}, rsp *map[string]any) error       { return nil }
+func (this *verbHandler) Getaway(req *struct{}, rsp *map[string]any) error    { return nil }
+
+func TestGetVerbs(t *testing.T) {
+	tests := []struct {
+		method       string
+		verbs        []string
+		methodPrefix bool
+		expect       []string
+	}{
+		{"GetUser", nil, false, []string{http.MethodGet, http.MethodPost}},
+		{"GetUser", nil, true, []string{http.MethodGet}},
+		{"DeleteUser", nil, true, []string{http.MethodDelete}},
+		{"PatchUser", nil, true, []string{http.MethodPatch}},
+		{"Getaway", nil, true, []string{http.MethodGet, http.MethodPost}},
+		{"Get", nil, true, []string{http.MethodGet, http.MethodPost}},
+		{"GetUser", []string{"put", "Patch"}, true, []string{http.MethodPut, http.MethodPatch}},
+	}
+	for _, tt := range tests {
+		h := &handler{methodName: tt.method, verbs: tt.verbs}
+		if verbs := h.getVerbs(tt.methodPrefix); !reflect.DeepEqual(verbs, tt.expect) {
+			t.Errorf("getVerbs(%s, %v) = %v, want %v", tt.method, tt.methodPrefix, verbs, tt.expect)
+		}
+	}
+}
+
+func TestVerbRouting(t *testing.T) {
+	tests := []struct {
+		methodPrefix bool
+		verb         string
+		path         string
+		code         int
+	}{
+		{false, http.MethodGet, "/verb_handler/get_user", http.StatusOK},
+		{false, http.MethodPost, "/verb_handler/get_user", http.StatusOK},
+		{false, http.MethodDelete, "/verb_handler/delete_user", http.StatusNotFound},
+		{true, http.MethodGet, "/verb_handler/get_user", http.StatusOK},
+		{true, http.MethodPost, "/verb_handler/get_user", http.StatusNotFound},
+		{true, http.MethodDelete, "/verb_handler/delete_user", http.StatusOK},
+		{true, http.MethodGet, "/verb_handler/delete_user", http.StatusNotFound},
+		{true, http.MethodPost, "/verb_handler/getaway", http.StatusOK},
+		{false, http.MethodPut, "/verb_handler/sync", http.StatusOK},
+		{true, http.MethodPatch, "/verb_handler/sync", http.StatusOK},
+		{true, http.MethodGet, "/verb_handler/sync", http.StatusNotFound},
+		{true, http.MethodPost, "/verb_handler/sync", http.StatusNotFound},
+	}
+	apps := map[bool]*App{}
+	for _, methodPrefix := range []bool{false, true} {
+		apps[methodPrefix] = New(WithConfig(Config{HTTPStatus: true, MethodPrefix: methodPrefix}))
+		apps[methodPrefix].Group("").Register(new(verbHandler))
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		apps[tt.methodPrefix].Handler().ServeHTTP(w, httptest.NewRequest(tt.verb, tt.path, nil))
+		if w.Code != tt.code {
+			t.Errorf("%s %s with methodPrefix %v = %d, want %d", tt.verb, tt.path, tt.methodPrefix, w.Code, tt.code)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/xinzf/kit/klog"
+	"github.com/xinzf/kit/ktrace"
+	"net"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// RequestIdHeader carries the id of a request, it is logged by AccessLog.
+const RequestIdHeader = ktrace.RequestIdHeader
+
+// Trace accepts the X-Request-Id and traceparent headers of a request, or
+// generates them, and stores the trace in the request context for klog and
+// rpc calls. The request id is sent back in the response.
+func Trace() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		t := ktrace.Start(c.GetHeader(ktrace.RequestIdHeader), c.GetHeader(ktrace.TraceparentHeader))
+		c.Request = c.Request.WithContext(ktrace.NewContext(c.Request.Context(), t))
+		c.Header(ktrace.RequestIdHeader, t.RequestId)
+		c.Next()
+	}
+}
+
+// Recovery turns a panicking handler into a Response with status 500 and logs
+// the panic with its stack through klog.
+func Recovery() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			r := recover()
+			if r == nil {
+				return
+			}
+
+			// a client that went away is not worth a stack trace
+			if err, ok := r.(error); ok && brokenPipe(err) {
+				klog.Args("path", c.Request.URL.Path, "err", err.Error()).Warn("Http connection broken")
+				_ = c.Error(err)
+				c.Abort()
+				return
+			}
+
+			klog.WithContext(c.Request.Context()).Args(
+				"method", c.Request.Method,
+				"path", c.Request.URL.Path,
+				"panic", fmt.Sprintf("%v", r),
+				"stack", string(debug.Stack()),
+			).Error("Http handler panic")
+
+			if c.Writer.Written() {
+				c.Abort()
+				return
+			}
+			writeResponse(c, Response{
+				Status: http.StatusInternalServerError,
+				Msg:    http.StatusText(http.StatusInternalServerError),
+			})
+		}()
+		c.Next()
+	}
+}
+
+func brokenPipe(err error) bool {
+	var opErr *net.OpError
+	if !errors.As(err, &opErr) {
+		return false
+	}
+	var syscallErr *os.SyscallError
+	if errors.As(opErr, &syscallErr) {
+		return errors.Is(syscallErr.Err, syscall.EPIPE) || errors.Is(syscallErr.Err, syscall.ECONNRESET)
+	}
+	return strings.Contains(strings.ToLower(opErr.Error()), "broken pipe")
+}
+
+// AccessLog logs every request with its status and latency through klog:
+// server errors at error level, client errors at warn level.
+func AccessLog() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		path := c.Request.URL.Path
+		if c.Request.URL.RawQuery != "" {
+			path += "?" + c.Request.URL.RawQuery
+		}
+
+		c.Next()
+
+		status := c.Writer.Status()
+		args := []any{
+			"method", c.Request.Method,
+			"path", path,
+			"status", status,
+			"latency", time.Since(start).String(),
+			"ip", c.ClientIP(),
+			"size", c.Writer.Size(),
+		}
+		if _, traced := ktrace.FromContext(c.Request.Context()); !traced {
+			if id := requestId(c); id != "" {
+				args = append(args, "request_id", id)
+			}
+		}
+		if len(c.Errors) > 0 {
+			args = append(args, "err", c.Errors.String())
+		}
+
+		logger := klog.WithContext(c.Request.Context()).Args(args...)
+		switch {
+		case status >= http.StatusInternalServerError:
+			logger.Error("Http request")
+		case status >= http.StatusBadRequest || len(c.Errors) > 0:
+			logger.Warn("Http request")
+		default:
+			logger.Info("Http request")
+		}
+	}
+}
+
+// requestId returns the id the response or, failing that, the request
+// carries.
+func requestId(c *gin.Context) string {
+	if id := c.Writer.Header().Get(RequestIdHeader); id != "" {
+		return id
+	}
+	return c.GetHeader(RequestIdHeader)
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/xinzf/kit/ktrace"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+type panicRequest struct{}
+
+type panicHandler struct{}
+
+func (this *panicHandler) Crash(req *panicRequest, rsp *map[string]any) error {
+	panic("crash")
+}
+
+func TestRecovery(t *testing.T) {
+	h := &panicHandler{}
+	g := gin.New()
+	g.Use(AccessLog(), Recovery())
+	method := reflect.ValueOf(h).MethodByName("Crash")
+	g.GET("/crash", newHandler("", "panicHandler", "Crash", method, "", nil).handlerFunc)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/crash", nil)
+	r.Header.Set(RequestIdHeader, "req-1")
+	g.ServeHTTP(w, r)
+
+	want := `{"status":500,"msg":"Internal Server Error","data":{}}`
+	if w.Code != http.StatusOK || w.Body.String() != want {
+		t.Errorf("GET /crash = %d %s, want 200 %s", w.Code, w.Body.String(), want)
+	}
+}
+
+func TestTrace(t *testing.T) {
+	g := gin.New()
+	g.ContextWithFallback = true
+	g.Use(Trace())
+	var traced ktrace.Trace
+	g.GET("/trace", func(c *gin.Context) {
+		traced, _ = ktrace.FromContext(c)
+	})
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/trace", nil)
+	r.Header.Set(RequestIdHeader, "req-1")
+	r.Header.Set(ktrace.TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	g.ServeHTTP(w, r)
+
+	if w.Header().Get(RequestIdHeader) != "req-1" {
+		t.Errorf("response request id = %q", w.Header().Get(RequestIdHeader))
+	}
+	if traced.RequestId != "req-1" || traced.TraceId != "4bf92f3577b34da6a3ce929d0e0e4736" || traced.ParentId != "00f067aa0ba902b7" {
+		t.Errorf("trace = %+v", traced)
+	}
+}
@@ -0,0 +1,280 @@
+package server
+
+import (
+	_ "embed"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/xinzf/kit/klog"
+	"github.com/xinzf/kit/server/openapi"
+	"html"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+//go:embed openapi.html
+var openapiViewer string
+
+// openapiIndex links the documents when no viewer assets are configured.
+//
+//go:embed openapi_index.html
+var openapiIndex string
+
+var (
+	fileResponseType   = reflect.TypeOf(&FileResponse{})
+	streamResponseType = reflect.TypeOf(&StreamResponse{})
+	rawResponseType    = reflect.TypeOf(&RawResponse{})
+	sseType            = reflect.TypeOf(&SSE{})
+	fieldErrorType     = reflect.TypeOf(FieldError{})
+)
+
+// OpenAPI returns the OpenAPI 3 document of the groups of the default App.
+func OpenAPI() *openapi.Document {
+	return defaultApp.OpenAPI()
+}
+
+// OpenAPI returns the OpenAPI 3 document of the groups of the App, titled and
+// versioned by its OpenAPIConfig. Responses are documented in the default
+// Response envelope.
+func (this *App) OpenAPI() *openapi.Document {
+	cfg := this.Config()
+	doc := &openapi.Document{
+		OpenAPI: openapi.Version,
+		Info: openapi.Info{
+			Title:       cfg.OpenAPI.Title,
+			Description: cfg.OpenAPI.Description,
+			Version:     cfg.OpenAPI.Version,
+		},
+		Paths: make(map[string]*openapi.PathItem),
+	}
+	schemas := openapi.NewSchemas()
+	tags := make(map[string]bool)
+
+	var walk func(group *HandlerGroup)
+	walk = func(group *HandlerGroup) {
+		for _, h := range group.handlers {
+			_, fullPath := group.routePath(h)
+			fullPath = openapiPath(fullPath)
+			item, found := doc.Paths[fullPath]
+			if !found {
+				item = &openapi.PathItem{}
+				doc.Paths[fullPath] = item
+			}
+
+			verbs := h.getVerbs(cfg.MethodPrefix)
+			for _, verb := range verbs {
+				op := h.operation(schemas, verb)
+				if len(verbs) > 1 {
+					op.OperationId += "_" + strings.ToLower(verb)
+				}
+				(*item)[strings.ToLower(verb)] = op
+				tags[op.Tags[0]] = true
+			}
+		}
+		for _, subGroup := range group.subGroups {
+			walk(subGroup)
+		}
+	}
+	for _, group := range this.groups {
+		walk(group)
+	}
+
+	for tag := range tags {
+		doc.Tags = append(doc.Tags, openapi.Tag{Name: tag})
+	}
+	sort.Slice(doc.Tags, func(i, j int) bool {
+		return doc.Tags[i].Name < doc.Tags[j].Name
+	})
+	doc.Components.Schemas = schemas.Components()
+	return doc
+}
+
+// openapiPath turns gin path params into OpenAPI ones: /user/:id to
+// /user/{id}.
+func openapiPath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") || strings.HasPrefix(segment, "*") {
+			segments[i] = "{" + segment[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+func (this *handler) operation(schemas *openapi.Schemas, verb string) *openapi.Operation {
+	tag := this.aliasName
+	if tag == "" {
+		tag = this.handlerName
+	}
+	op := &openapi.Operation{
+		OperationId: fmt.Sprintf("%s.%s", snakeString(tag), snakeString(this.methodName)),
+		Tags:        []string{tag},
+		Responses:   make(map[string]*openapi.Response),
+	}
+	summary, description, _ := strings.Cut(this.description, "\n")
+	op.Summary = strings.TrimSpace(summary)
+	op.Description = strings.TrimSpace(description)
+
+	var fields []reflect.StructField
+	if this.inNum > 1 && !this.isUpload && this.req.Kind() == reflect.Ptr {
+		fields = openapi.Fields(this.req)
+	}
+
+	for _, param := range this.paths[this.methodName] {
+		p := &openapi.Parameter{Name: param, In: "path", Required: true, Schema: &openapi.Schema{Type: "string"}}
+		for _, field := range fields {
+			if strings.Split(field.Tag.Get("uri"), ",")[0] == param {
+				p.Schema = schemas.Field(field)
+				p.Description = p.Schema.Description
+				p.Schema.Description = ""
+			}
+		}
+		op.Parameters = append(op.Parameters, p)
+	}
+
+	hasBody := verb != http.MethodGet && verb != http.MethodDelete && verb != http.MethodHead
+	for _, field := range fields {
+		p := &openapi.Parameter{Required: openapi.Required(field)}
+		if name := strings.Split(field.Tag.Get("header"), ",")[0]; name != "" && name != "-" {
+			p.Name, p.In = name, "header"
+		} else if field.Tag.Get("uri") != "" {
+			continue
+		} else if form := strings.Split(field.Tag.Get("form"), ",")[0]; form != "" && form != "-" {
+			fieldType := field.Type
+			for fieldType.Kind() == reflect.Ptr {
+				fieldType = fieldType.Elem()
+			}
+			// nested objects have no query form
+			if fieldType.Kind() == reflect.Struct && fieldType != timeType {
+				continue
+			}
+			p.Name, p.In = form, "query"
+		} else {
+			continue
+		}
+		p.Schema = schemas.Field(field)
+		p.Description = p.Schema.Description
+		p.Schema.Description = ""
+		op.Parameters = append(op.Parameters, p)
+	}
+
+	if hasBody && this.inNum > 1 {
+		switch {
+		case this.isUpload:
+			op.RequestBody = &openapi.RequestBody{
+				Required: true,
+				Content: map[string]*openapi.MediaType{
+					"multipart/form-data": {Schema: &openapi.Schema{
+						Type:                 "object",
+						AdditionalProperties: &openapi.Schema{Type: "string", Format: "binary"},
+					}},
+				},
+			}
+		default:
+			op.RequestBody = &openapi.RequestBody{
+				Content: map[string]*openapi.MediaType{
+					"application/json": {Schema: schemas.Of(this.req)},
+				},
+			}
+		}
+	}
+
+	switch this.rsp {
+	case fileResponseType, streamResponseType, rawResponseType:
+		op.Responses["200"] = &openapi.Response{
+			Description: "OK",
+			Content: map[string]*openapi.MediaType{
+				"application/octet-stream": {Schema: &openapi.Schema{Type: "string", Format: "binary"}},
+			},
+		}
+	case sseType:
+		op.Responses["200"] = &openapi.Response{
+			Description: "Server-sent events",
+			Content: map[string]*openapi.MediaType{
+				"text/event-stream": {Schema: &openapi.Schema{Type: "string"}},
+			},
+		}
+	default:
+		data := &openapi.Schema{Type: "object"}
+		if this.rsp != nil {
+			data = schemas.Of(this.rsp)
+		}
+		op.Responses["200"] = envelope("OK", data)
+	}
+
+	if this.inNum > 1 && !this.isUpload {
+		op.Responses["400"] = envelope("Invalid request", &openapi.Schema{
+			Type: "object",
+			Properties: map[string]*openapi.Schema{
+				"errors": {Type: "array", Items: schemas.Of(fieldErrorType)},
+			},
+		})
+	}
+	op.Responses["default"] = envelope("Error", &openapi.Schema{Type: "object"})
+	return op
+}
+
+// envelope documents data wrapped in the default Response.
+func envelope(description string, data *openapi.Schema) *openapi.Response {
+	return &openapi.Response{
+		Description: description,
+		Content: map[string]*openapi.MediaType{
+			"application/json": {Schema: &openapi.Schema{
+				Type: "object",
+				Properties: map[string]*openapi.Schema{
+					"status": {Type: "integer", Description: "0 on success"},
+					"msg":    {Type: "string"},
+					"data":   data,
+				},
+				Required: []string{"status", "msg", "data"},
+			}},
+		},
+	}
+}
+
+// serveOpenAPI serves the document as openapi.json and openapi.yaml under
+// cfg.Path, with a viewer at the path itself, or links to them when no
+// viewer assets are configured.
+func (this *App) serveOpenAPI(g *gin.Engine, cfg OpenAPIConfig) {
+	path := strings.TrimRight(cfg.Path, "/")
+	if path == "" {
+		return
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	doc := this.OpenAPI()
+	jsonDoc, err := doc.JSON()
+	if err != nil {
+		klog.Args("err", err.Error()).Warn("Encode openapi document failed")
+		return
+	}
+	yamlDoc, err := doc.YAML()
+	if err != nil {
+		klog.Args("err", err.Error()).Warn("Encode openapi document failed")
+		return
+	}
+
+	page := openapiIndex
+	if cfg.Assets != "" {
+		page = openapiViewer
+	}
+	viewer := strings.NewReplacer(
+		"{{title}}", html.EscapeString(doc.Info.Title),
+		"{{assets}}", strings.TrimRight(cfg.Assets, "/"),
+		"{{spec}}", path+"/openapi.json",
+		"{{yaml}}", path+"/openapi.yaml",
+	).Replace(page)
+
+	g.GET(path, func(c *gin.Context) {
+		c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(viewer))
+	})
+	g.GET(path+"/openapi.json", func(c *gin.Context) {
+		c.Data(http.StatusOK, "application/json; charset=utf-8", jsonDoc)
+	})
+	g.GET(path+"/openapi.yaml", func(c *gin.Context) {
+		c.Data(http.StatusOK, "application/yaml; charset=utf-8", yamlDoc)
+	})
+}
@@ -0,0 +1,236 @@
+package openapi
+
+import (
+	"encoding/json"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+	rawJSONType  = reflect.TypeOf(json.RawMessage{})
+)
+
+// Schemas derives schemas from Go types. Named structs are collected as
+// components and referenced.
+type Schemas struct {
+	components map[string]*Schema
+	names      map[reflect.Type]string
+}
+
+func NewSchemas() *Schemas {
+	return &Schemas{
+		components: make(map[string]*Schema),
+		names:      make(map[reflect.Type]string),
+	}
+}
+
+// Components returns the schemas of the named structs met so far.
+func (this *Schemas) Components() map[string]*Schema {
+	return this.components
+}
+
+// Of returns the schema of typ.
+func (this *Schemas) Of(typ reflect.Type) *Schema {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+
+	switch typ {
+	case timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case durationType:
+		return &Schema{Type: "integer", Format: "int64", Description: "nanoseconds"}
+	case rawJSONType:
+		return &Schema{}
+	}
+
+	switch typ.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Float32:
+		return &Schema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if typ.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: this.Of(typ.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: this.Of(typ.Elem())}
+	case reflect.Struct:
+		if typ.Name() == "" {
+			return this.object(typ)
+		}
+		return &Schema{Ref: "#/components/schemas/" + this.component(typ)}
+	default:
+		// interfaces, and types without a JSON form, accept anything
+		return &Schema{}
+	}
+}
+
+func (this *Schemas) component(typ reflect.Type) string {
+	if name, found := this.names[typ]; found {
+		return name
+	}
+
+	name := componentName(typ.Name())
+	if _, taken := this.components[name]; taken {
+		name = componentName(path.Base(typ.PkgPath()) + "." + typ.Name())
+	}
+	this.names[typ] = name
+	// reserve the name before walking the fields, for recursive types
+	this.components[name] = &Schema{}
+	*this.components[name] = *this.object(typ)
+	return name
+}
+
+func componentName(name string) string {
+	return strings.NewReplacer("[", "_", "]", "", "/", "_", "*", "", " ", "", ",", "_").Replace(name)
+}
+
+func (this *Schemas) object(typ reflect.Type) *Schema {
+	schema := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	for _, field := range Fields(typ) {
+		name := JSONName(field)
+		if name == "" {
+			continue
+		}
+		schema.Properties[name] = this.Field(field)
+		if Required(field) {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+	return schema
+}
+
+// Field returns the schema of a struct field, with the description, example
+// and validation rules of its tags.
+func (this *Schemas) Field(field reflect.StructField) *Schema {
+	schema := this.Of(field.Type)
+	if schema.Ref != "" {
+		// siblings of $ref are ignored in OpenAPI 3.0, allOf keeps the
+		// description
+		if description := field.Tag.Get("description"); description != "" {
+			return &Schema{Description: description, AllOf: []*Schema{schema}}
+		}
+		return schema
+	}
+
+	schema.Description = field.Tag.Get("description")
+	if example := field.Tag.Get("example"); example != "" {
+		schema.Example = example
+	}
+	applyRules(schema, field)
+	return schema
+}
+
+// applyRules copies the oneof, min, max and len rules of the binding and
+// validate tags.
+func applyRules(schema *Schema, field reflect.StructField) {
+	for _, tag := range []string{"binding", "validate"} {
+		for _, rule := range strings.Split(field.Tag.Get(tag), ",") {
+			key, param, _ := strings.Cut(rule, "=")
+			switch key {
+			case "oneof":
+				for _, val := range strings.Fields(param) {
+					schema.Enum = append(schema.Enum, val)
+				}
+			case "min", "gte", "max", "lte", "len":
+				n, err := strconv.ParseFloat(param, 64)
+				if err != nil {
+					continue
+				}
+				setBound(schema, key, n)
+			}
+		}
+	}
+}
+
+func setBound(schema *Schema, key string, n float64) {
+	lower := key == "min" || key == "gte" || key == "len"
+	upper := key == "max" || key == "lte" || key == "len"
+	switch schema.Type {
+	case "integer", "number":
+		if lower {
+			schema.Minimum = &n
+		}
+		if upper {
+			schema.Maximum = &n
+		}
+	case "string":
+		length := int(n)
+		if lower {
+			schema.MinLength = &length
+		}
+		if upper {
+			schema.MaxLength = &length
+		}
+	}
+}
+
+// Fields returns the exported fields of a struct, with the fields of
+// embedded structs without a json name flattened in.
+func Fields(typ reflect.Type) []reflect.StructField {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ.Kind() != reflect.Struct {
+		return nil
+	}
+
+	fields := make([]reflect.StructField, 0, typ.NumField())
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if field.Anonymous {
+			fieldType := field.Type
+			if fieldType.Kind() == reflect.Ptr {
+				fieldType = fieldType.Elem()
+			}
+			if fieldType.Kind() == reflect.Struct && fieldType != timeType && field.Tag.Get("json") == "" {
+				fields = append(fields, Fields(fieldType)...)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+// JSONName returns the name of a field in JSON, empty when it is skipped.
+func JSONName(field reflect.StructField) string {
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return ""
+	}
+	if name := strings.Split(tag, ",")[0]; name != "" {
+		return name
+	}
+	return field.Name
+}
+
+// Required reports whether the binding or validate tag of field requires it.
+func Required(field reflect.StructField) bool {
+	for _, tag := range []string{"binding", "validate"} {
+		for _, rule := range strings.Split(field.Tag.Get(tag), ",") {
+			if rule == "required" {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,152 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type docAddress struct {
+	City string `json:"city" description:"city name"`
+}
+
+type docUserRequest struct {
+	ID      int    `json:"id" uri:"id" description:"user id"`
+	Token   string `json:"-" header:"X-Token" binding:"required"`
+	Name    string `json:"name" binding:"required,min=2,max=32" example:"kit"`
+	Role    string `json:"role" validate:"oneof=admin user"`
+	Address *docAddress
+	Page    int `json:"-" form:"page"`
+}
+
+type docUser struct {
+	ID        int            `json:"id"`
+	Name      string         `json:"name"`
+	CreatedAt time.Time      `json:"created_at"`
+	Friends   []*docUser     `json:"friends"`
+	Tags      map[string]int `json:"tags"`
+}
+
+type docHandler struct{}
+
+func (this *docHandler) Paths() map[string][]string {
+	return map[string][]string{"Update": {"id"}}
+}
+
+func (this *docHandler) Methods() map[string][]string {
+	return map[string][]string{"Update": {"put"}, "Get": {"get"}}
+}
+
+func (this *docHandler) Descriptions() map[string]string {
+	return map[string]string{"Update": "Update a user\nRenames the user and changes its role."}
+}
+
+func (this *docHandler) Update(req *docUserRequest, rsp *docUser) error {
+	return nil
+}
+
+func (this *docHandler) Get(req *docUserRequest, rsp *docUser) error {
+	return nil
+}
+
+func (this *docHandler) Download(req *docUserRequest, rsp *FileResponse) error {
+	return nil
+}
+
+func TestOpenAPI(t *testing.T) {
+	Group("/docs-test").Register(new(docHandler))
+
+	doc := OpenAPI()
+	item := doc.Paths["/docs-test/doc_handler/update/{id}"]
+	if item == nil {
+		t.Fatalf("missing update path in %v", doc.Paths)
+	}
+	op := (*item)["put"]
+	if op == nil || len(*item) != 1 {
+		t.Fatalf("update operations = %v", *item)
+	}
+	if op.Summary != "Update a user" || op.Description != "Renames the user and changes its role." {
+		t.Errorf("update summary = %q, description = %q", op.Summary, op.Description)
+	}
+
+	params := map[string]string{}
+	for _, p := range op.Parameters {
+		params[p.In+":"+p.Name] = p.Schema.Type
+	}
+	if params["path:id"] != "integer" || params["header:X-Token"] != "string" || params["query:page"] != "integer" || len(params) != 3 {
+		t.Errorf("update parameters = %v", params)
+	}
+	if op.RequestBody == nil || op.RequestBody.Content["application/json"].Schema.Ref != "#/components/schemas/docUserRequest" {
+		t.Errorf("update request body = %+v", op.RequestBody)
+	}
+
+	get := (*doc.Paths["/docs-test/doc_handler/get"])["get"]
+	queries := []string{}
+	for _, p := range get.Parameters {
+		if p.In == "query" {
+			queries = append(queries, p.Name)
+		}
+	}
+	if strings.Join(queries, ",") != "page" || get.RequestBody != nil {
+		t.Errorf("get queries = %v, body = %v", queries, get.RequestBody)
+	}
+
+	download := doc.Paths["/docs-test/doc_handler/download"]
+	if rsp := (*download)["post"].Responses["200"]; rsp.Content["application/octet-stream"] == nil {
+		t.Errorf("download response = %+v", rsp)
+	}
+
+	req := doc.Components.Schemas["docUserRequest"]
+	name := req.Properties["name"]
+	if *name.MinLength != 2 || *name.MaxLength != 32 || name.Example != "kit" {
+		t.Errorf("name schema = %+v", name)
+	}
+	if len(req.Properties["role"].Enum) != 2 || strings.Join(req.Required, ",") != "name" {
+		t.Errorf("request schema = %+v", req)
+	}
+	if _, found := req.Properties["Token"]; found {
+		t.Error("fields with json:\"-\" should not be documented")
+	}
+	user := doc.Components.Schemas["docUser"]
+	if user.Properties["friends"].Items.Ref != "#/components/schemas/docUser" || user.Properties["created_at"].Format != "date-time" {
+		t.Errorf("user schema = %+v", user)
+	}
+
+	app := New(WithConfig(Config{OpenAPI: OpenAPIConfig{Path: "/openapi"}}))
+	app.Group("/docs-test").Register(new(docHandler))
+	g := app.Handler()
+	for _, path := range []string{"/openapi", "/openapi/openapi.json", "/openapi/openapi.yaml"} {
+		w := httptest.NewRecorder()
+		g.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "openapi") {
+			t.Errorf("GET %s = %d", path, w.Code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	g.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/openapi", nil))
+	if strings.Contains(w.Body.String(), "<script") {
+		t.Errorf("viewer without assets loads scripts: %s", w.Body.String())
+	}
+
+	// the config section of the default App loads no viewer assets either
+	cfg := LoadConfig("server")
+	if cfg.OpenAPI.Assets != "" {
+		t.Errorf("default assets = %q, want none", cfg.OpenAPI.Assets)
+	}
+	cfg.OpenAPI.Path = "/openapi"
+	w = httptest.NewRecorder()
+	New(WithConfig(cfg)).Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/openapi", nil))
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), "<script") {
+		t.Errorf("viewer of the server config = %d %s", w.Code, w.Body.String())
+	}
+
+	app = New(WithConfig(Config{OpenAPI: OpenAPIConfig{Path: "/openapi", Assets: "/static/swagger-ui/"}}))
+	w = httptest.NewRecorder()
+	app.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/openapi", nil))
+	if !strings.Contains(w.Body.String(), `src="/static/swagger-ui/swagger-ui-bundle.js"`) {
+		t.Errorf("viewer with assets = %s", w.Body.String())
+	}
+}
@@ -2,20 +2,30 @@ package server
 
 import (
 	"github.com/gin-gonic/gin"
+	"time"
 )
 
-//
-//type RequestVerify interface {
-//	Verify() (int, error)
-//}
-//
-//type RequestCacheSetter interface {
-//	CacheId() (string, time.Duration)
-//}
-//
-//type RequestCacheDeleter interface {
-//	DeleteCachePatterns() (patterns []string)
-//}
+// RequestValidator is implemented by requests with rules struct tags cannot
+// express, such as ones spanning several fields. Validate runs after the
+// binding and validate tags passed; a kerrors.Error keeps its code, any
+// other error is answered with status 400.
+type RequestValidator interface {
+	Validate() error
+}
+
+// RequestCacheSetter is implemented by requests whose successful responses
+// are cached. CacheId returns the key and lifetime of the entry, an empty key
+// skips the cache.
+type RequestCacheSetter interface {
+	CacheId() (key string, ttl time.Duration)
+}
+
+// RequestCacheDeleter is implemented by requests that invalidate cached
+// responses. The keys matching the returned patterns are deleted once the
+// handler succeeded.
+type RequestCacheDeleter interface {
+	DeleteCachePatterns() (patterns []string)
+}
 
 type AfterRequestInterface interface {
 	AfterRequest(c *gin.Context)
@@ -56,3 +66,23 @@ type HandlerName interface {
 type HandlerPath interface {
 	Paths() map[string][]string
 }
+
+// HandlerMethods declares the HTTP verbs of handler methods, keyed by method
+// name. Methods not listed answer GET and POST.
+type HandlerMethods interface {
+	Methods() map[string][]string
+}
+
+// HandlerDescription describes handler methods in the OpenAPI document, keyed
+// by method name. The first line is the summary of the operation, the rest
+// its description.
+type HandlerDescription interface {
+	Descriptions() map[string]string
+}
+
+// HandlerIgnore lists exported methods of a handler that are not routes, so
+// they are neither bound nor reported as invalid. Fields of the handler
+// tagged ignore:"Helper,Other" list them as well.
+type HandlerIgnore interface {
+	IgnoreMethods() []string
+}
@@ -8,17 +8,14 @@ import (
 )
 
 type HandlerGroup struct {
+	app         *App
 	parent      *HandlerGroup
 	path        string
 	middlewares []gin.HandlerFunc
 	handlers    []*handler
 	subGroups   []*HandlerGroup
-}
-
-var groups []*HandlerGroup
-
-func init() {
-	groups = make([]*HandlerGroup, 0)
+	// problems are the methods Register could not route.
+	problems []string
 }
 
 func (this *HandlerGroup) getPath() string {
@@ -30,6 +27,22 @@ func (this *HandlerGroup) getPath() string {
 	return currPath
 }
 
+// routePath returns the path h is bound to, relative to the group, and the
+// full path it answers on.
+func (this *HandlerGroup) routePath(h *handler) (path, fullPath string) {
+	if this.path == "" || this.path == "/" {
+		path = strings.TrimLeft(h.getBindPath(h.paths), "/")
+	} else {
+		path = h.getBindPath(h.paths)
+	}
+
+	fullPath = fmt.Sprintf("%s%s", this.getPath(), path)
+	if !strings.HasPrefix(fullPath, "/") {
+		fullPath = "/" + fullPath
+	}
+	return path, fullPath
+}
+
 func (this *HandlerGroup) Group(path string, middlewares ...gin.HandlerFunc) *HandlerGroup {
 	for _, subGroup := range this.subGroups {
 		if subGroup.path == path {
@@ -39,6 +52,7 @@ func (this *HandlerGroup) Group(path string, middlewares ...gin.HandlerFunc) *Ha
 	}
 
 	g := &HandlerGroup{
+		app:         this.app,
 		path:        path,
 		middlewares: middlewares,
 		handlers:    []*handler{},
@@ -69,33 +83,97 @@ func (this *HandlerGroup) Register(apiHandler ...interface{}) *HandlerGroup {
 			paths = pathValue[0].Interface().(map[string][]string)
 		}
 
+		var methods map[string][]string = nil
+		if refType.Implements(reflect.TypeOf(new(HandlerMethods)).Elem()) {
+			methodsMethod := refValue.MethodByName("Methods")
+			methodsValue := methodsMethod.Call([]reflect.Value{})
+			methods = methodsValue[0].Interface().(map[string][]string)
+		}
+
+		var descriptions map[string]string = nil
+		if refType.Implements(reflect.TypeOf(new(HandlerDescription)).Elem()) {
+			descriptionMethod := refValue.MethodByName("Descriptions")
+			descriptionValue := descriptionMethod.Call([]reflect.Value{})
+			descriptions = descriptionValue[0].Interface().(map[string]string)
+		}
+
+		ignored := ignoredMethods(handler)
+
+	methods:
 		for i := 0; i < refValue.NumMethod(); i++ {
 			methodName := refType.Method(i).Name
+			if ignored[methodName] {
+				continue
+			}
 
 			for _, h := range this.handlers {
-				if h.handlerName == handlerName && h.methodName == methodName {
-					continue
+				if h.pkgPath == refType.Elem().PkgPath() && h.handlerName == handlerName && h.methodName == methodName {
+					continue methods
 				}
 			}
 
-			hdl := newHandler(refType.Elem().PkgPath(), handlerName, methodName, refValue.Method(i), alisName, paths)
-			if hdl == nil {
+			if err := checkSignature(refValue.Method(i).Type()); err != nil {
+				if err != errNotHandler {
+					this.addProblem(fmt.Sprintf("%s.%s: %s", handlerName, methodName, err.Error()))
+				}
 				continue
 			}
 
+			hdl := newHandler(refType.Elem().PkgPath(), handlerName, methodName, refValue.Method(i), alisName, paths)
+			hdl.verbs = methods[methodName]
+			hdl.description = descriptions[methodName]
+
 			this.handlers = append(this.handlers, hdl)
 		}
 	}
 	return this
 }
 
-func Group(path string, middlewares ...gin.HandlerFunc) *HandlerGroup {
-	g := &HandlerGroup{
-		path:        path,
-		middlewares: middlewares,
-		handlers:    []*handler{},
-		subGroups:   []*HandlerGroup{},
+// addProblem records problem once, registering a handler twice finds it
+// again.
+func (this *HandlerGroup) addProblem(problem string) {
+	for _, p := range this.problems {
+		if p == problem {
+			return
+		}
 	}
-	groups = append(groups, g)
-	return g
+	this.problems = append(this.problems, problem)
+}
+
+// RegistrationError reports every problem found while binding the routes of
+// an App in strict mode.
+type RegistrationError struct {
+	Problems []string
+}
+
+func (this *RegistrationError) Error() string {
+	return fmt.Sprintf("server: %d handler registration problems:\n  %s", len(this.Problems), strings.Join(this.Problems, "\n  "))
+}
+
+// ignoredMethods collects the methods handler excludes through HandlerIgnore
+// or ignore tags.
+func ignoredMethods(handler interface{}) map[string]bool {
+	ignored := make(map[string]bool)
+	if ignore, ok := handler.(HandlerIgnore); ok {
+		for _, name := range ignore.IgnoreMethods() {
+			ignored[name] = true
+		}
+	}
+	typ := reflect.TypeOf(handler).Elem()
+	if typ.Kind() != reflect.Struct {
+		return ignored
+	}
+	for i := 0; i < typ.NumField(); i++ {
+		for _, name := range strings.Split(typ.Field(i).Tag.Get("ignore"), ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				ignored[name] = true
+			}
+		}
+	}
+	return ignored
+}
+
+// Group adds a group to the default App.
+func Group(path string, middlewares ...gin.HandlerFunc) *HandlerGroup {
+	return defaultApp.Group(path, middlewares...)
 }
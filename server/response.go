@@ -0,0 +1,260 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+	"github.com/xinzf/kit/kerrors"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// ResponseRenderer is implemented by responses written as they are instead
+// of being wrapped in Response and encoded as JSON. Handler errors are still
+// answered with a JSON Response.
+type ResponseRenderer interface {
+	Render(c *gin.Context) error
+}
+
+var rendererType = reflect.TypeOf(new(ResponseRenderer)).Elem()
+
+// contentDisposition names a download, attachment tells the browser to save
+// it instead of showing it.
+func contentDisposition(name string, attachment bool) string {
+	disposition := "inline"
+	if attachment {
+		disposition = "attachment"
+	}
+	if name == "" {
+		return disposition
+	}
+	return mime.FormatMediaType(disposition, map[string]string{"filename": name})
+}
+
+// FileResponse serves a file from disk, or Content, with Range,
+// If-Modified-Since and HEAD support.
+type FileResponse struct {
+	// Path is the file on disk, used when Content is nil.
+	Path    string
+	Content io.ReadSeeker
+	// Name is the file name shown to the client, defaults to the base of
+	// Path. Its extension sets the content type unless ContentType does.
+	Name        string
+	ContentType string
+	ModTime     time.Time
+	// Inline shows the file in the browser instead of downloading it.
+	Inline bool
+}
+
+func (this *FileResponse) Render(c *gin.Context) error {
+	content := this.Content
+	modTime := this.ModTime
+	name := this.Name
+	if content == nil {
+		f, err := os.Open(this.Path)
+		if errors.Is(err, os.ErrNotExist) {
+			return kerrors.Wrap(err, 404, "file not found")
+		}
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = f.Close()
+		}()
+
+		stat, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		if stat.IsDir() {
+			return fmt.Errorf("%s is a directory", this.Path)
+		}
+		if modTime.IsZero() {
+			modTime = stat.ModTime()
+		}
+		content = f
+	} else if closer, ok := content.(io.Closer); ok {
+		defer func() {
+			_ = closer.Close()
+		}()
+	}
+	if name == "" {
+		name = filepath.Base(this.Path)
+	}
+
+	if this.ContentType != "" {
+		c.Header("Content-Type", this.ContentType)
+	}
+	c.Header("Content-Disposition", contentDisposition(name, !this.Inline))
+	http.ServeContent(c.Writer, c.Request, name, modTime, content)
+	return nil
+}
+
+// StreamResponse copies Reader, or the output of Write, to the client as it
+// is produced, for exports too large to buffer. A Reader that can seek is
+// served with Range support.
+type StreamResponse struct {
+	ContentType string
+	// Name, when set, makes the client download the stream under that name.
+	Name   string
+	Reader io.Reader
+	Write  func(w io.Writer) error
+}
+
+func (this *StreamResponse) Render(c *gin.Context) error {
+	if closer, ok := this.Reader.(io.Closer); ok {
+		defer func() {
+			_ = closer.Close()
+		}()
+	}
+
+	contentType := this.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	c.Header("Content-Type", contentType)
+	if this.Name != "" {
+		c.Header("Content-Disposition", contentDisposition(this.Name, true))
+	}
+
+	if seeker, ok := this.Reader.(io.ReadSeeker); ok {
+		http.ServeContent(c.Writer, c.Request, this.Name, time.Time{}, seeker)
+		return nil
+	}
+
+	c.Status(http.StatusOK)
+	if this.Write != nil {
+		return this.Write(flushWriter{c.Writer})
+	}
+	if this.Reader == nil {
+		return nil
+	}
+	_, err := io.Copy(flushWriter{c.Writer}, this.Reader)
+	return err
+}
+
+// flushWriter flushes every write so the client sees the stream as it is
+// produced.
+type flushWriter struct {
+	w gin.ResponseWriter
+}
+
+func (this flushWriter) Write(p []byte) (int, error) {
+	n, err := this.w.Write(p)
+	this.w.Flush()
+	return n, err
+}
+
+// RawResponse writes Body as it is.
+type RawResponse struct {
+	// Status defaults to 200.
+	Status      int
+	ContentType string
+	Header      http.Header
+	Body        []byte
+}
+
+func (this *RawResponse) Render(c *gin.Context) error {
+	for key, values := range this.Header {
+		for _, value := range values {
+			c.Writer.Header().Add(key, value)
+		}
+	}
+	status := this.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	contentType := this.ContentType
+	if contentType == "" {
+		contentType = http.DetectContentType(this.Body)
+	}
+	c.Data(status, contentType, this.Body)
+	return nil
+}
+
+// SSEEvent is one server-sent event. Data that is not a string is encoded as
+// JSON.
+type SSEEvent struct {
+	Id    string
+	Event string
+	Data  any
+	Retry time.Duration
+}
+
+// SSE streams the events sent on Events until it is closed or the client
+// goes away. The handler returns right after starting the goroutine that
+// feeds Events, which must select on Done with every send: once the client
+// is gone nothing reads Events anymore.
+type SSE struct {
+	Events <-chan SSEEvent
+	// KeepAlive sends a comment at this interval so proxies keep idle
+	// streams open, 0 disables it.
+	KeepAlive time.Duration
+
+	doneOnce sync.Once
+	done     chan struct{}
+}
+
+// Done is closed once the stream ended, because Events was closed, the
+// client went away or a write failed.
+func (this *SSE) Done() <-chan struct{} {
+	return this.doneChan()
+}
+
+func (this *SSE) doneChan() chan struct{} {
+	this.doneOnce.Do(func() {
+		this.done = make(chan struct{})
+	})
+	return this.done
+}
+
+func (this *SSE) Render(c *gin.Context) error {
+	defer close(this.doneChan())
+
+	c.Header("Content-Type", sse.ContentType)
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	var keepAlive <-chan time.Time
+	if this.KeepAlive > 0 {
+		ticker := time.NewTicker(this.KeepAlive)
+		defer ticker.Stop()
+		keepAlive = ticker.C
+	}
+
+	ctx := c.Request.Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-keepAlive:
+			if _, err := io.WriteString(c.Writer, ":\n\n"); err != nil {
+				return err
+			}
+		case event, ok := <-this.Events:
+			if !ok {
+				return nil
+			}
+			err := sse.Encode(c.Writer, sse.Event{
+				Id:    event.Id,
+				Event: event.Event,
+				Retry: uint(event.Retry / time.Millisecond),
+				Data:  event.Data,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		c.Writer.Flush()
+	}
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type downloadRequest struct {
+	Name string `form:"name"`
+}
+
+type downloadHandler struct {
+	dir string
+}
+
+func (this *downloadHandler) File(req *downloadRequest, rsp *FileResponse) error {
+	rsp.Path = filepath.Join(this.dir, req.Name)
+	return nil
+}
+
+func (this *downloadHandler) Export(req *downloadRequest, rsp *StreamResponse) error {
+	rsp.ContentType = "text/csv"
+	rsp.Name = "export.csv"
+	rsp.Write = func(w io.Writer) error {
+		_, err := io.WriteString(w, "id,name\n1,kit\n")
+		return err
+	}
+	return nil
+}
+
+func (this *downloadHandler) Raw(req *downloadRequest, rsp *RawResponse) error {
+	rsp.ContentType = "image/svg+xml"
+	rsp.Body = []byte("<svg/>")
+	return nil
+}
+
+func (this *downloadHandler) Events(req *downloadRequest, rsp *SSE) error {
+	events := make(chan SSEEvent, 2)
+	events <- SSEEvent{Event: "progress", Data: map[string]int{"done": 50}}
+	events <- SSEEvent{Id: "2", Data: "finished"}
+	close(events)
+	rsp.Events = events
+	return nil
+}
+
+func TestRenderResponses(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("0123456789"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	h := &downloadHandler{dir: dir}
+	g := gin.New()
+	for _, name := range []string{"File", "Export", "Raw", "Events"} {
+		method := reflect.ValueOf(h).MethodByName(name)
+		g.GET("/"+name, newHandler("", "downloadHandler", name, method, "", nil).handlerFunc)
+	}
+	do := func(path string, header ...string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			r.Header.Set(header[i], header[i+1])
+		}
+		g.ServeHTTP(w, r)
+		return w
+	}
+
+	w := do("/File?name=a.txt", "Range", "bytes=2-4")
+	if w.Code != http.StatusPartialContent || w.Body.String() != "234" {
+		t.Errorf("File range = %d %q", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("Content-Disposition"); got != `attachment; filename=a.txt` {
+		t.Errorf("File disposition = %q", got)
+	}
+
+	w = do("/File?name=missing.txt")
+	if w.Header().Get("Content-Type") != "application/json; charset=utf-8" || !strings.Contains(w.Body.String(), `"status":404`) {
+		t.Errorf("missing File = %s %s", w.Header().Get("Content-Type"), w.Body.String())
+	}
+
+	w = do("/Export")
+	if w.Header().Get("Content-Type") != "text/csv" || w.Body.String() != "id,name\n1,kit\n" {
+		t.Errorf("Export = %s %q", w.Header().Get("Content-Type"), w.Body.String())
+	}
+
+	w = do("/Raw")
+	if w.Header().Get("Content-Type") != "image/svg+xml" || w.Body.String() != "<svg/>" {
+		t.Errorf("Raw = %s %q", w.Header().Get("Content-Type"), w.Body.String())
+	}
+
+	w = do("/Events")
+	want := "event:progress\ndata:{\"done\":50}\n\nid:2\ndata:finished\n\n"
+	if w.Header().Get("Content-Type") != "text/event-stream" || w.Body.String() != want {
+		t.Errorf("Events = %s %q", w.Header().Get("Content-Type"), w.Body.String())
+	}
+}
+
+type sseHandler struct {
+	stopped chan struct{}
+}
+
+func (this *sseHandler) Ticks(req *downloadRequest, rsp *SSE) error {
+	events := make(chan SSEEvent)
+	go func() {
+		defer close(this.stopped)
+		for i := 0; ; i++ {
+			select {
+			case events <- SSEEvent{Data: i}:
+			case <-rsp.Done():
+				return
+			}
+		}
+	}()
+	rsp.Events = events
+	return nil
+}
+
+func TestSSEDone(t *testing.T) {
+	h := &sseHandler{stopped: make(chan struct{})}
+	g := gin.New()
+	g.GET("/Ticks", newHandler("", "sseHandler", "Ticks", reflect.ValueOf(h).MethodByName("Ticks"), "", nil).handlerFunc)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	g.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/Ticks", nil).WithContext(ctx))
+
+	select {
+	case <-h.stopped:
+	case <-time.After(time.Second):
+		t.Fatal("producer still running after the client went away")
+	}
+}
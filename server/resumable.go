@@ -0,0 +1,650 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/xinzf/kit/cache"
+	"github.com/xinzf/kit/kerrors"
+	"github.com/xinzf/kit/klog"
+	"github.com/xinzf/kit/server/storage"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Resumable uploads, enabled by WithChunkStore or Upload.Resumable, are sent
+// to the route of an UploadRequest handler in steps named by the
+// Upload-Action header:
+//
+//   - initiate: a JSON body {"name", "size", "field", "fields", "chunkSize"}
+//     starts an upload and answers its ChunkStatus.
+//   - chunk: the body is chunk Upload-Chunk, counted from 0, of upload
+//     Upload-Id. Every chunk but the last is ChunkSize long.
+//   - status: answers the ChunkStatus of Upload-Id, to resume from.
+//   - complete: checks every chunk is there and that the file hashes to
+//     Upload-Checksum, a hex SHA-256, then runs the handler. The file is
+//     returned by UploadRequest.Get(field), the fields by GetPostForm.
+const (
+	UploadActionHeader   = "Upload-Action"
+	UploadIdHeader       = "Upload-Id"
+	UploadChunkHeader    = "Upload-Chunk"
+	UploadChecksumHeader = "Upload-Checksum"
+)
+
+var (
+	ErrUploadNotFound   = errors.New("upload not found")
+	ErrUploadCompleting = errors.New("upload is completing")
+)
+
+// ChunkUpload is a resumable upload.
+type ChunkUpload struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	// Field is the form field the file is read from, file by default.
+	Field     string            `json:"field"`
+	Fields    map[string]string `json:"fields,omitempty"`
+	ChunkSize int64             `json:"chunkSize"`
+	ExpiresAt time.Time         `json:"expiresAt"`
+	// Completing is set by ChunkStore.Get once the upload is claimed. It is
+	// only a hint to refuse chunks early, AddChunk is what refuses them.
+	Completing bool `json:"-"`
+}
+
+// Chunks returns the number of chunks of the upload.
+func (this *ChunkUpload) Chunks() int {
+	return int((this.Size + this.ChunkSize - 1) / this.ChunkSize)
+}
+
+func (this *ChunkUpload) chunkLength(index int) int64 {
+	if index == this.Chunks()-1 {
+		return this.Size - int64(index)*this.ChunkSize
+	}
+	return this.ChunkSize
+}
+
+// newChunkKey returns a key no other write of chunk index uses, so a chunk
+// sent again never changes the data a completing request reads.
+func (this *ChunkUpload) newChunkKey(index int) (string, error) {
+	nonce := make([]byte, 8)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("chunks/%s/%d-%s", this.Id, index, hex.EncodeToString(nonce)), nil
+}
+
+// ChunkStatus answers the steps of a resumable upload.
+type ChunkStatus struct {
+	Id        string `json:"id"`
+	Size      int64  `json:"size"`
+	ChunkSize int64  `json:"chunkSize"`
+	Chunks    int    `json:"chunks"`
+	// Uploaded lists the received chunks in order.
+	Uploaded []int `json:"uploaded"`
+	// Offset is the length received without gaps from the start, where a
+	// sequential client resumes.
+	Offset    int64     `json:"offset"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// ChunkStore keeps the state of resumable uploads, their chunks are kept in
+// a storage.Storage under the keys it records. Chunks of uploads expiring
+// before they complete are left to the lifecycle rules of that storage.
+type ChunkStore interface {
+	// Create saves upload until its ExpiresAt.
+	Create(ctx context.Context, upload *ChunkUpload) error
+	// Get returns ErrUploadNotFound for unknown or expired uploads, and sets
+	// Completing on claimed ones.
+	Get(ctx context.Context, id string) (*ChunkUpload, error)
+	// AddChunk records key as the stored chunk index and returns the key it
+	// replaces, if any. Checking the claim and recording the key must be
+	// atomic: it returns ErrUploadCompleting once the upload is claimed.
+	AddChunk(ctx context.Context, upload *ChunkUpload, index int, key string) (previous string, err error)
+	// Chunks returns the keys of the received chunks by index.
+	Chunks(ctx context.Context, id string) (map[int]string, error)
+	// Claim atomically marks the upload as completing, so its handler runs
+	// once. It returns false when another request claimed it already.
+	Claim(ctx context.Context, upload *ChunkUpload) (bool, error)
+	// Unclaim lets a failed completion be retried.
+	Unclaim(ctx context.Context, id string) error
+	Delete(ctx context.Context, id string) error
+}
+
+// chunkStores returns the stores of resumable uploads: the ones set by
+// WithChunkStore, else redis and ChunkDir when Upload.Resumable is on.
+// Otherwise resumable uploads are answered with 501.
+func (this *App) chunkStores() (ChunkStore, storage.Storage, error) {
+	this.chunkMu.Lock()
+	defer this.chunkMu.Unlock()
+	if this.chunkStore != nil && this.chunkStorage != nil {
+		return this.chunkStore, this.chunkStorage, nil
+	}
+
+	cfg := this.Config().Upload
+	if !cfg.Resumable && this.chunkStore == nil {
+		return nil, nil, kerrors.New(http.StatusNotImplemented, "resumable uploads are not enabled")
+	}
+	if this.chunkStore == nil {
+		client, err := redisClient()
+		if err != nil {
+			return nil, nil, kerrors.Wrap(err, http.StatusServiceUnavailable, "resumable upload store is unavailable")
+		}
+		this.chunkStore = NewRedisChunkStore(client)
+	}
+	if this.chunkStorage == nil {
+		dir := cfg.ChunkDir
+		if dir == "" {
+			dir = filepath.Join(os.TempDir(), "kit-uploads")
+		}
+		this.chunkStorage = storage.NewLocal(dir)
+	}
+	return this.chunkStore, this.chunkStorage, nil
+}
+
+// redisClient returns the client of the cache package, which panics when
+// redis cannot be reached.
+func redisClient() (client *redis.Client, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("connect redis failed: %v", r)
+		}
+	}()
+	return cache.Redis(), nil
+}
+
+// resume runs the resumable upload step action. It answers every step but a
+// valid complete, after which the handler runs with the assembled file.
+func (this *UploadRequest) resume(action string) (answered bool, err error) {
+	c := this.ctx
+	ctx := c.Request.Context()
+	cfg := appOf(c).Config().Upload
+	store, chunks, err := appOf(c).chunkStores()
+	if err != nil {
+		return false, err
+	}
+
+	if action == "initiate" {
+		upload, err := newChunkUpload(c, cfg)
+		if err != nil {
+			return false, err
+		}
+		if err = store.Create(ctx, upload); err != nil {
+			return false, err
+		}
+		writeResponse(c, Response{Data: chunkStatus(upload, nil)})
+		return true, nil
+	}
+
+	upload, err := store.Get(ctx, c.GetHeader(UploadIdHeader))
+	if errors.Is(err, ErrUploadNotFound) {
+		return false, kerrors.New(http.StatusNotFound, err.Error())
+	}
+	if err != nil {
+		return false, err
+	}
+
+	switch action {
+	case "chunk":
+		index, err := strconv.Atoi(c.GetHeader(UploadChunkHeader))
+		if err != nil || index < 0 || index >= upload.Chunks() {
+			return false, kerrors.Newf(http.StatusBadRequest, "invalid chunk %s of %d", c.GetHeader(UploadChunkHeader), upload.Chunks())
+		}
+		if upload.Completing {
+			return false, kerrors.New(http.StatusConflict, ErrUploadCompleting.Error())
+		}
+		length := upload.chunkLength(index)
+		if c.Request.ContentLength >= 0 && c.Request.ContentLength != length {
+			return false, kerrors.Newf(http.StatusBadRequest, "chunk %d must be %d bytes", index, length)
+		}
+
+		key, err := upload.newChunkKey(index)
+		if err != nil {
+			return false, err
+		}
+		body := &countingReader{r: io.LimitReader(c.Request.Body, length)}
+		if err = chunks.Put(ctx, key, body, length, "application/octet-stream"); err != nil {
+			return false, err
+		}
+		if body.n != length {
+			_ = chunks.Delete(ctx, key)
+			return false, kerrors.Newf(http.StatusBadRequest, "chunk %d must be %d bytes", index, length)
+		}
+		previous, err := store.AddChunk(ctx, upload, index, key)
+		if err != nil {
+			_ = chunks.Delete(ctx, key)
+			if errors.Is(err, ErrUploadCompleting) {
+				return false, kerrors.New(http.StatusConflict, err.Error())
+			}
+			return false, err
+		}
+		// the upload was not claimed when the chunk was replaced, so no
+		// request reads the previous one
+		if previous != "" && previous != key {
+			if err = chunks.Delete(ctx, previous); err != nil {
+				klog.Args("upload", upload.Id, "err", err.Error()).Warn("Delete upload chunk failed")
+			}
+		}
+		fallthrough
+
+	case "status":
+		uploaded, err := store.Chunks(ctx, upload.Id)
+		if err != nil {
+			return false, err
+		}
+		writeResponse(c, Response{Data: chunkStatus(upload, uploaded)})
+		return true, nil
+
+	case "complete":
+		claimed, err := store.Claim(ctx, upload)
+		if err != nil {
+			return false, err
+		}
+		if !claimed {
+			return false, kerrors.Newf(http.StatusConflict, "upload %s is already completing", upload.Id)
+		}
+
+		// no chunk is recorded once the upload is claimed, so the keys read
+		// now are the data verified and handed to the handler
+		uploaded, err := store.Chunks(ctx, upload.Id)
+		if err == nil && len(uploaded) != upload.Chunks() {
+			err = kerrors.Newf(http.StatusConflict, "%d of %d chunks are uploaded", len(uploaded), upload.Chunks()).
+				WithDetail("status", chunkStatus(upload, uploaded))
+		}
+		if err == nil {
+			this.chunkKeys = make([]string, upload.Chunks())
+			for index, key := range uploaded {
+				this.chunkKeys[index] = key
+			}
+			err = this.verify(upload, chunks, cfg)
+		}
+		if err != nil {
+			_ = store.Unclaim(ctx, upload.Id)
+			return false, err
+		}
+
+		this.chunked = upload
+		this.fields = upload.Fields
+		this.files = map[string][]*File{upload.Field: {upload.file(ctx, chunks, this.chunkKeys)}}
+		return false, nil
+	}
+	return false, kerrors.Newf(http.StatusBadRequest, "unknown %s %s", UploadActionHeader, action)
+}
+
+// verify checks the assembled file of upload hashes to Upload-Checksum and
+// has an allowed type.
+func (this *UploadRequest) verify(upload *ChunkUpload, chunks storage.Storage, cfg UploadConfig) error {
+	checksum := strings.ToLower(strings.TrimPrefix(this.ctx.GetHeader(UploadChecksumHeader), "sha256="))
+	if checksum == "" {
+		return kerrors.Newf(http.StatusBadRequest, "missing %s header", UploadChecksumHeader)
+	}
+	file := upload.file(this.ctx.Request.Context(), chunks, this.chunkKeys)
+	sum, err := file.SHA256()
+	if err != nil {
+		return err
+	}
+	if sum != checksum {
+		return kerrors.Newf(http.StatusBadRequest, "checksum mismatch, the file hashes to %s", sum)
+	}
+	return file.checkType(cfg)
+}
+
+// settle deletes a completed resumable upload once its handler succeeded,
+// else lets it be completed again.
+func (this *UploadRequest) settle() {
+	ctx := this.ctx.Request.Context()
+	store, chunks, err := appOf(this.ctx).chunkStores()
+	if err != nil {
+		return
+	}
+	if !this.completed {
+		if err = store.Unclaim(ctx, this.chunked.Id); err != nil {
+			klog.Args("upload", this.chunked.Id, "err", err.Error()).Warn("Unclaim upload failed")
+		}
+		return
+	}
+	for _, key := range this.chunkKeys {
+		if err := chunks.Delete(ctx, key); err != nil {
+			klog.Args("upload", this.chunked.Id, "err", err.Error()).Warn("Delete upload chunk failed")
+		}
+	}
+	if err = store.Delete(ctx, this.chunked.Id); err != nil {
+		klog.Args("upload", this.chunked.Id, "err", err.Error()).Warn("Delete upload failed")
+	}
+}
+
+func newChunkUpload(c *gin.Context, cfg UploadConfig) (*ChunkUpload, error) {
+	upload := &ChunkUpload{}
+	if err := jsoniter.NewDecoder(c.Request.Body).Decode(upload); err != nil {
+		return nil, kerrors.Wrap(err, http.StatusBadRequest, "invalid upload")
+	}
+	if upload.Name == "" || upload.Size < 0 {
+		return nil, kerrors.New(http.StatusBadRequest, "an upload needs a name and a size")
+	}
+	if err := (&File{name: upload.Name, size: upload.Size}).checkName(cfg); err != nil {
+		return nil, err
+	}
+
+	if upload.Field == "" {
+		upload.Field = "file"
+	}
+	chunkSize := cfg.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = defaultChunkSize
+	}
+	if cfg.MaxRequestSize > 0 && chunkSize > cfg.MaxRequestSize {
+		chunkSize = cfg.MaxRequestSize
+	}
+	if upload.ChunkSize <= 0 || upload.ChunkSize > chunkSize {
+		upload.ChunkSize = chunkSize
+	}
+	ttl := cfg.ChunkTTL
+	if ttl <= 0 {
+		ttl = defaultChunkTTL
+	}
+	upload.ExpiresAt = time.Now().Add(ttl)
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	upload.Id = hex.EncodeToString(id)
+	return upload, nil
+}
+
+func chunkStatus(upload *ChunkUpload, uploaded map[int]string) ChunkStatus {
+	status := ChunkStatus{
+		Id:        upload.Id,
+		Size:      upload.Size,
+		ChunkSize: upload.ChunkSize,
+		Chunks:    upload.Chunks(),
+		Uploaded:  []int{},
+		ExpiresAt: upload.ExpiresAt,
+	}
+	for index := range uploaded {
+		status.Uploaded = append(status.Uploaded, index)
+	}
+	sort.Ints(status.Uploaded)
+	for i, index := range status.Uploaded {
+		if index != i {
+			break
+		}
+		status.Offset += upload.chunkLength(index)
+	}
+	return status
+}
+
+// file streams the chunks stored under keys in order.
+func (this *ChunkUpload) file(ctx context.Context, chunks storage.Storage, keys []string) *File {
+	return &File{
+		name: this.Name,
+		size: this.Size,
+		open: func() (io.ReadCloser, error) {
+			return &chunkReader{ctx: ctx, chunks: chunks, keys: keys}, nil
+		},
+	}
+}
+
+type chunkReader struct {
+	ctx     context.Context
+	chunks  storage.Storage
+	keys    []string
+	index   int
+	current io.ReadCloser
+}
+
+func (this *chunkReader) Read(p []byte) (int, error) {
+	for {
+		if this.current == nil {
+			if this.index >= len(this.keys) {
+				return 0, io.EOF
+			}
+			r, err := this.chunks.Get(this.ctx, this.keys[this.index])
+			if err != nil {
+				return 0, err
+			}
+			this.current = r
+			this.index++
+		}
+
+		n, err := this.current.Read(p)
+		if errors.Is(err, io.EOF) {
+			_ = this.current.Close()
+			this.current = nil
+			err = nil
+		}
+		if n > 0 || err != nil {
+			return n, err
+		}
+	}
+}
+
+func (this *chunkReader) Close() error {
+	if this.current == nil {
+		return nil
+	}
+	return this.current.Close()
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (this *countingReader) Read(p []byte) (int, error) {
+	n, err := this.r.Read(p)
+	this.n += int64(n)
+	return n, err
+}
+
+type redisChunkStore struct {
+	client redis.UniversalClient
+}
+
+// NewRedisChunkStore returns a ChunkStore on client, keeping every upload
+// in upload:<id>, the keys of its chunks in the hash upload:<id>:chunks and
+// its claim in upload:<id>:claim.
+func NewRedisChunkStore(client redis.UniversalClient) ChunkStore {
+	return &redisChunkStore{client: client}
+}
+
+func (this *redisChunkStore) Create(ctx context.Context, upload *ChunkUpload) error {
+	bts, err := jsoniter.Marshal(upload)
+	if err != nil {
+		return err
+	}
+	return this.client.Set(ctx, "upload:"+upload.Id, bts, time.Until(upload.ExpiresAt)).Err()
+}
+
+func (this *redisChunkStore) Get(ctx context.Context, id string) (*ChunkUpload, error) {
+	bts, err := this.client.Get(ctx, "upload:"+id).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrUploadNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	upload := &ChunkUpload{}
+	if err = jsoniter.Unmarshal(bts, upload); err != nil {
+		return nil, err
+	}
+	claimed, err := this.client.Exists(ctx, "upload:"+id+":claim").Result()
+	if err != nil {
+		return nil, err
+	}
+	upload.Completing = claimed > 0
+	return upload, nil
+}
+
+// addChunkScript records a chunk key unless the upload is claimed, answering
+// {0} when it is and {1, previous key} otherwise.
+var addChunkScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 1 then
+	return {0}
+end
+local previous = redis.call("HGET", KEYS[2], ARGV[1]) or ""
+redis.call("HSET", KEYS[2], ARGV[1], ARGV[2])
+redis.call("EXPIREAT", KEYS[2], ARGV[3])
+return {1, previous}
+`)
+
+func (this *redisChunkStore) AddChunk(ctx context.Context, upload *ChunkUpload, index int, key string) (string, error) {
+	keys := []string{"upload:" + upload.Id + ":claim", "upload:" + upload.Id + ":chunks"}
+	res, err := addChunkScript.Run(ctx, this.client, keys, index, key, upload.ExpiresAt.Unix()).Slice()
+	if err != nil {
+		return "", err
+	}
+	if added, _ := res[0].(int64); added == 0 {
+		return "", ErrUploadCompleting
+	}
+	previous, _ := res[1].(string)
+	return previous, nil
+}
+
+func (this *redisChunkStore) Chunks(ctx context.Context, id string) (map[int]string, error) {
+	fields, err := this.client.HGetAll(ctx, "upload:"+id+":chunks").Result()
+	if err != nil {
+		return nil, err
+	}
+	chunks := make(map[int]string, len(fields))
+	for field, key := range fields {
+		index, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, err
+		}
+		chunks[index] = key
+	}
+	return chunks, nil
+}
+
+func (this *redisChunkStore) Claim(ctx context.Context, upload *ChunkUpload) (bool, error) {
+	return this.client.SetNX(ctx, "upload:"+upload.Id+":claim", 1, time.Until(upload.ExpiresAt)).Result()
+}
+
+func (this *redisChunkStore) Unclaim(ctx context.Context, id string) error {
+	return this.client.Del(ctx, "upload:"+id+":claim").Err()
+}
+
+func (this *redisChunkStore) Delete(ctx context.Context, id string) error {
+	return this.client.Del(ctx, "upload:"+id, "upload:"+id+":chunks", "upload:"+id+":claim").Err()
+}
+
+type memoryChunkUpload struct {
+	upload  *ChunkUpload
+	chunks  map[int]string
+	claimed bool
+}
+
+// MemoryChunkStore is a ChunkStore held in process, for tests and single
+// instance servers.
+type MemoryChunkStore struct {
+	mu      sync.Mutex
+	uploads map[string]*memoryChunkUpload
+}
+
+func NewMemoryChunkStore() *MemoryChunkStore {
+	return &MemoryChunkStore{uploads: make(map[string]*memoryChunkUpload)}
+}
+
+// get returns the live entry of id, the lock must be held.
+func (this *MemoryChunkStore) get(id string) (*memoryChunkUpload, error) {
+	entry, found := this.uploads[id]
+	if !found {
+		return nil, ErrUploadNotFound
+	}
+	if time.Now().After(entry.upload.ExpiresAt) {
+		delete(this.uploads, id)
+		return nil, ErrUploadNotFound
+	}
+	return entry, nil
+}
+
+func (this *MemoryChunkStore) Create(ctx context.Context, upload *ChunkUpload) error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	stored := *upload
+	this.uploads[upload.Id] = &memoryChunkUpload{upload: &stored, chunks: make(map[int]string)}
+	return nil
+}
+
+func (this *MemoryChunkStore) Get(ctx context.Context, id string) (*ChunkUpload, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	entry, err := this.get(id)
+	if err != nil {
+		return nil, err
+	}
+	upload := *entry.upload
+	upload.Completing = entry.claimed
+	return &upload, nil
+}
+
+func (this *MemoryChunkStore) AddChunk(ctx context.Context, upload *ChunkUpload, index int, key string) (string, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	entry, err := this.get(upload.Id)
+	if err != nil {
+		return "", err
+	}
+	if entry.claimed {
+		return "", ErrUploadCompleting
+	}
+	previous := entry.chunks[index]
+	entry.chunks[index] = key
+	return previous, nil
+}
+
+func (this *MemoryChunkStore) Claim(ctx context.Context, upload *ChunkUpload) (bool, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	entry, err := this.get(upload.Id)
+	if err != nil {
+		return false, err
+	}
+	if entry.claimed {
+		return false, nil
+	}
+	entry.claimed = true
+	return true, nil
+}
+
+func (this *MemoryChunkStore) Unclaim(ctx context.Context, id string) error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	if entry, found := this.uploads[id]; found {
+		entry.claimed = false
+	}
+	return nil
+}
+
+func (this *MemoryChunkStore) Chunks(ctx context.Context, id string) (map[int]string, error) {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	entry, err := this.get(id)
+	if err != nil {
+		return nil, err
+	}
+	chunks := make(map[int]string, len(entry.chunks))
+	for index, key := range entry.chunks {
+		chunks[index] = key
+	}
+	return chunks, nil
+}
+
+func (this *MemoryChunkStore) Delete(ctx context.Context, id string) error {
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	delete(this.uploads, id)
+	return nil
+}
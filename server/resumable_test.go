@@ -0,0 +1,289 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/xinzf/kit/server/storage"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+type resumableResponse struct {
+	Name   string `json:"name"`
+	Owner  string `json:"owner"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+type resumableHandler struct{}
+
+func (this *resumableHandler) Video(req *UploadRequest, rsp *resumableResponse) error {
+	file, err := req.Get("video")
+	if err != nil {
+		return err
+	}
+	rsp.Name, rsp.Size = file.FileName(), file.Size()
+	rsp.Owner, _ = req.GetPostForm("owner")
+	rsp.SHA256, err = file.SHA256()
+	return err
+}
+
+type resumableResult struct {
+	Status int         `json:"status"`
+	Msg    string      `json:"msg"`
+	Data   ChunkStatus `json:"data"`
+}
+
+func TestResumableUpload(t *testing.T) {
+	store, chunks := NewMemoryChunkStore(), storage.NewMemory()
+	app := New(WithConfig(Config{HTTPStatus: true, Upload: UploadConfig{ChunkSize: 8}}), WithChunkStore(store, chunks))
+	app.Group("").Register(new(resumableHandler))
+
+	content := []byte("0123456789abcdefghij")
+	sum := sha256.Sum256(content)
+	checksum := hex.EncodeToString(sum[:])
+
+	send := func(headers map[string]string, body []byte) (int, []byte) {
+		r := httptest.NewRequest(http.MethodPost, "/resumable_handler/video", bytes.NewReader(body))
+		for name, value := range headers {
+			r.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		app.Handler().ServeHTTP(w, r)
+		return w.Code, w.Body.Bytes()
+	}
+	step := func(action string, headers map[string]string, body []byte) (int, resumableResult) {
+		if headers == nil {
+			headers = map[string]string{}
+		}
+		headers[UploadActionHeader] = action
+		code, data := send(headers, body)
+		var result resumableResult
+		_ = jsoniter.Unmarshal(data, &result)
+		return code, result
+	}
+
+	_, initiated := step("initiate", nil, []byte(`{"name":"clip.mp4","size":20,"field":"video","fields":{"owner":"kit"}}`))
+	upload := initiated.Data
+	if upload.Id == "" || upload.Chunks != 3 || upload.ChunkSize != 8 {
+		t.Fatalf("initiate = %+v", initiated)
+	}
+	chunk := func(index int, data []byte) (int, resumableResult) {
+		return step("chunk", map[string]string{UploadIdHeader: upload.Id, UploadChunkHeader: strconv.Itoa(index)}, data)
+	}
+	complete := func(checksum string) (int, []byte) {
+		return send(map[string]string{UploadActionHeader: "complete", UploadIdHeader: upload.Id, UploadChecksumHeader: checksum}, nil)
+	}
+
+	if code, _ := chunk(2, content[16:]); code != http.StatusOK {
+		t.Fatalf("chunk 2 = %d", code)
+	}
+	if code, _ := chunk(0, []byte("resent!!")); code != http.StatusOK {
+		t.Fatalf("chunk 0 = %d", code)
+	}
+	if code, _ := chunk(0, content[:8]); code != http.StatusOK {
+		t.Fatalf("resent chunk 0 = %d", code)
+	}
+	if code, _ := chunk(1, content[8:12]); code != http.StatusBadRequest {
+		t.Errorf("short chunk = %d", code)
+	}
+	if code, _ := chunk(3, content[:4]); code != http.StatusBadRequest {
+		t.Errorf("chunk out of range = %d", code)
+	}
+
+	_, status := step("status", map[string]string{UploadIdHeader: upload.Id}, nil)
+	if len(status.Data.Uploaded) != 2 || status.Data.Uploaded[1] != 2 || status.Data.Offset != 8 {
+		t.Errorf("status = %+v", status.Data)
+	}
+	if code, _ := complete(checksum); code != http.StatusConflict {
+		t.Errorf("complete with missing chunks = %d", code)
+	}
+
+	if code, _ := chunk(1, content[8:16]); code != http.StatusOK {
+		t.Fatalf("chunk 1 = %d", code)
+	}
+	if code, _ := complete("deadbeef"); code != http.StatusBadRequest {
+		t.Errorf("complete with a wrong checksum = %d", code)
+	}
+	code, body := complete("sha256=" + checksum)
+	var done struct {
+		Data resumableResponse `json:"data"`
+	}
+	_ = jsoniter.Unmarshal(body, &done)
+	if code != http.StatusOK || done.Data != (resumableResponse{Name: "clip.mp4", Owner: "kit", Size: 20, SHA256: checksum}) {
+		t.Errorf("complete = %d %s", code, body)
+	}
+
+	if code, _ := step("status", map[string]string{UploadIdHeader: upload.Id}, nil); code != http.StatusNotFound {
+		t.Errorf("status of a completed upload = %d", code)
+	}
+	if keys := chunks.Keys(); len(keys) != 0 {
+		t.Errorf("chunks left = %v", keys)
+	}
+}
+
+func TestResumableUploadDisabled(t *testing.T) {
+	app := New(WithConfig(Config{HTTPStatus: true}))
+	app.Group("").Register(new(resumableHandler))
+
+	r := httptest.NewRequest(http.MethodPost, "/resumable_handler/video", bytes.NewReader([]byte(`{"name":"clip.mp4","size":20}`)))
+	r.Header.Set(UploadActionHeader, "initiate")
+	w := httptest.NewRecorder()
+	app.Handler().ServeHTTP(w, r)
+	if w.Code != http.StatusNotImplemented {
+		t.Errorf("initiate without chunk store = %d %s", w.Code, w.Body.String())
+	}
+}
+
+type blockingUploadHandler struct {
+	entered chan struct{}
+	release chan struct{}
+	calls   int32
+}
+
+func (this *blockingUploadHandler) Video(req *UploadRequest, rsp *resumableResponse) error {
+	atomic.AddInt32(&this.calls, 1)
+	this.entered <- struct{}{}
+	<-this.release
+	file, err := req.Get("video")
+	if err != nil {
+		return err
+	}
+	rsp.SHA256, err = file.SHA256()
+	return err
+}
+
+func TestResumableUploadCompleteOnce(t *testing.T) {
+	handler := &blockingUploadHandler{entered: make(chan struct{}, 1), release: make(chan struct{})}
+	app := New(WithConfig(Config{HTTPStatus: true, Upload: UploadConfig{ChunkSize: 8}}), WithChunkStore(NewMemoryChunkStore(), storage.NewMemory()))
+	app.Group("").Register(handler)
+
+	content := []byte("01234567")
+	sum := sha256.Sum256(content)
+	send := func(headers map[string]string, body []byte) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/blocking_upload_handler/video", bytes.NewReader(body))
+		for name, value := range headers {
+			r.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		app.Handler().ServeHTTP(w, r)
+		return w
+	}
+
+	var initiated resumableResult
+	_ = jsoniter.Unmarshal(send(map[string]string{UploadActionHeader: "initiate"}, []byte(`{"name":"clip.mp4","size":8,"field":"video"}`)).Body.Bytes(), &initiated)
+	id := initiated.Data.Id
+	chunk := map[string]string{UploadActionHeader: "chunk", UploadIdHeader: id, UploadChunkHeader: "0"}
+	if w := send(chunk, content); w.Code != http.StatusOK {
+		t.Fatalf("chunk = %d %s", w.Code, w.Body.String())
+	}
+
+	complete := map[string]string{UploadActionHeader: "complete", UploadIdHeader: id, UploadChecksumHeader: hex.EncodeToString(sum[:])}
+	first := make(chan *httptest.ResponseRecorder)
+	go func() {
+		first <- send(complete, nil)
+	}()
+	<-handler.entered
+
+	if w := send(complete, nil); w.Code != http.StatusConflict {
+		t.Errorf("concurrent complete = %d %s", w.Code, w.Body.String())
+	}
+	if w := send(chunk, content); w.Code != http.StatusConflict {
+		t.Errorf("chunk while completing = %d %s", w.Code, w.Body.String())
+	}
+	close(handler.release)
+
+	if w := <-first; w.Code != http.StatusOK {
+		t.Errorf("complete = %d %s", w.Code, w.Body.String())
+	}
+	if calls := atomic.LoadInt32(&handler.calls); calls != 1 {
+		t.Errorf("handler ran %d times", calls)
+	}
+}
+
+// gatedStorage blocks the next Put once gate is set, until release is
+// closed.
+type gatedStorage struct {
+	*storage.Memory
+	gate    chan struct{}
+	release chan struct{}
+}
+
+func (this *gatedStorage) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	if this.gate != nil {
+		gate := this.gate
+		this.gate = nil
+		close(gate)
+		<-this.release
+	}
+	return this.Memory.Put(ctx, key, r, size, contentType)
+}
+
+func TestResumableUploadChunkWhileCompleting(t *testing.T) {
+	handler := &blockingUploadHandler{entered: make(chan struct{}, 1), release: make(chan struct{})}
+	chunks := &gatedStorage{Memory: storage.NewMemory()}
+	app := New(WithConfig(Config{HTTPStatus: true, Upload: UploadConfig{ChunkSize: 8}}), WithChunkStore(NewMemoryChunkStore(), chunks))
+	app.Group("").Register(handler)
+
+	content, forged := []byte("01234567"), []byte("forged!!")
+	sum := sha256.Sum256(content)
+	send := func(headers map[string]string, body []byte) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/blocking_upload_handler/video", bytes.NewReader(body))
+		for name, value := range headers {
+			r.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		app.Handler().ServeHTTP(w, r)
+		return w
+	}
+
+	var initiated resumableResult
+	_ = jsoniter.Unmarshal(send(map[string]string{UploadActionHeader: "initiate"}, []byte(`{"name":"clip.mp4","size":8,"field":"video"}`)).Body.Bytes(), &initiated)
+	id := initiated.Data.Id
+	chunk := map[string]string{UploadActionHeader: "chunk", UploadIdHeader: id, UploadChunkHeader: "0"}
+	if w := send(chunk, content); w.Code != http.StatusOK {
+		t.Fatalf("chunk = %d %s", w.Code, w.Body.String())
+	}
+
+	// the forged chunk passes the early claim check, then waits in Put
+	// while the upload is completed
+	chunks.gate, chunks.release = make(chan struct{}), make(chan struct{})
+	gate := chunks.gate
+	forging := make(chan *httptest.ResponseRecorder)
+	go func() {
+		forging <- send(chunk, forged)
+	}()
+	<-gate
+
+	complete := map[string]string{UploadActionHeader: "complete", UploadIdHeader: id, UploadChecksumHeader: hex.EncodeToString(sum[:])}
+	completing := make(chan *httptest.ResponseRecorder)
+	go func() {
+		completing <- send(complete, nil)
+	}()
+	<-handler.entered
+
+	close(chunks.release)
+	if w := <-forging; w.Code != http.StatusConflict {
+		t.Errorf("chunk while completing = %d %s", w.Code, w.Body.String())
+	}
+	close(handler.release)
+
+	w := <-completing
+	var done struct {
+		Data resumableResponse `json:"data"`
+	}
+	_ = jsoniter.Unmarshal(w.Body.Bytes(), &done)
+	if w.Code != http.StatusOK || done.Data.SHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("complete = %d %s, want the verified chunk", w.Code, w.Body.String())
+	}
+	if keys := chunks.Keys(); len(keys) != 0 {
+		t.Errorf("chunks left = %v", keys)
+	}
+}
@@ -2,101 +2,69 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"github.com/gin-gonic/gin"
-	"github.com/gogf/gf/os/glog"
-	"github.com/liushuochen/gotable"
-	"github.com/liushuochen/gotable/cell"
-	"github.com/liushuochen/gotable/table"
-	"github.com/xinzf/kit/container/kcfg"
-	"strings"
+	"github.com/xinzf/kit/klog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
-func Run(ctx context.Context, before ...func() error) {
-	if len(before) > 0 {
-		for _, f := range before {
-			if err := f(); err != nil {
-				glog.Panic(err)
-			}
-		}
-	}
+// OnShutdown registers hooks on the default App, which are called, in
+// order, after the http server has stopped accepting requests and drained
+// the in-flight ones.
+func OnShutdown(hooks ...func(ctx context.Context) error) {
+	defaultApp.OnShutdown(hooks...)
+}
 
-	debug := kcfg.Get[bool]("server.debug")
-	port := kcfg.Get[int]("server.port")
-	if debug {
-		gin.SetMode(gin.DebugMode)
-	} else {
-		gin.SetMode(gin.ReleaseMode)
-	}
-	g := gin.Default()
+// Run serves the groups of the default App until ctx is done or the process
+// receives SIGINT/SIGTERM, then shuts the server down gracefully within
+// server.shutdownTimeout.
+func Run(ctx context.Context, before ...func() error) error {
+	return defaultApp.Run(ctx, before...)
+}
 
-	var (
-		tb  *table.Table
-		err error
-	)
+// Handler returns the router of the default App.
+func Handler() http.Handler {
+	return defaultApp.Handler()
+}
 
-	tb, err = gotable.Create("#", "URI", "Handler", "Method")
-	if err != nil {
-		glog.Panicf("Create print table failed: %s", err.Error())
-	}
+func (this *App) serve(ctx context.Context, srv *http.Server, timeout time.Duration) error {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	tb.Align("#", cell.AlignLeft)
-	tb.Align("URI", cell.AlignLeft)
-	tb.Align("Handler", cell.AlignLeft)
-	tb.Align("Method", cell.AlignLeft)
-	tb.SetColumnColor("#", gotable.Underline, gotable.Write, gotable.NoneBackground)
-	tb.SetColumnColor("URI", gotable.Underline, gotable.Write, gotable.NoneBackground)
-	tb.SetColumnColor("Handler", gotable.Underline, gotable.Write, gotable.NoneBackground)
-	tb.SetColumnColor("Method", gotable.Underline, gotable.Write, gotable.NoneBackground)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.ListenAndServe()
+	}()
 
-	num := 0
-	var listen = func(ginGroup *gin.RouterGroup, _group *HandlerGroup) {}
-	listen = func(ginGroup *gin.RouterGroup, _group *HandlerGroup) {
-		for _, h := range _group.handlers {
-			path := ""
-			if _group.path == "" {
-				_group.path = "/"
-			}
-			if _group.path == "/" {
-				path = strings.TrimLeft(h.getBindPath(h.paths), "/")
-			} else {
-				path = h.getBindPath(h.paths)
-			}
+	select {
+	case err := <-errCh:
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+		return err
+	case <-ctx.Done():
+	}
 
-			evalPath := func() string {
-				str := fmt.Sprintf("%s%s", _group.getPath(), path)
-				if !strings.HasPrefix(str, "/") {
-					str = "/" + str
-				}
-				return str
-			}
+	klog.Args("timeout", timeout.String()).Info("Server is shutting down")
 
-			num++
-			_ = tb.AddRow([]string{
-				fmt.Sprintf("%d", num),
-				evalPath(),
-				fmt.Sprintf("%s/%s", h.pkgPath, h.handlerName),
-				h.methodName,
-			})
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
 
-			ginGroup.OPTIONS(path, func(c *gin.Context) {
-				c.JSON(200, nil)
-			})
-			ginGroup.GET(path, h.handlerFunc)
-			ginGroup.POST(path, h.handlerFunc)
-		}
-		for _, subGroup := range _group.subGroups {
-			_subGinGroup := ginGroup.Group(subGroup.path, subGroup.middlewares...)
-			listen(_subGinGroup, subGroup)
+	err := srv.Shutdown(shutdownCtx)
+	for _, hook := range this.shutdownHooks {
+		if hookErr := hook(shutdownCtx); hookErr != nil {
+			klog.Args("err", hookErr.Error()).Error("Server shutdown hook failed")
+			if err == nil {
+				err = hookErr
+			}
 		}
 	}
-
-	for _, _group := range groups {
-		listen(g.Group(_group.path, _group.middlewares...), _group)
+	if err != nil {
+		return fmt.Errorf("server shutdown failed: %s", err.Error())
 	}
-
-	fmt.Println()
-	fmt.Printf("[SERVER] server listen on port: %d, Total: %d\n", port, num)
-	fmt.Println(tb)
-	_ = g.Run(fmt.Sprintf(":%d", port))
+	return nil
 }
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// serveSlow serves a handler on addr which blocks until release is closed,
+// and returns once a request is in flight on it.
+func serveSlow(t *testing.T, app *App, ctx context.Context, addr string, timeout time.Duration) (release chan struct{}, served, requested chan error) {
+	started, release := make(chan struct{}), make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ping", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})
+
+	served = make(chan error, 1)
+	go func() {
+		served <- app.serve(ctx, &http.Server{Addr: addr, Handler: mux}, timeout)
+	}()
+	for {
+		if rsp, err := http.Get("http://" + addr + "/ping"); err == nil {
+			_ = rsp.Body.Close()
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	requested = make(chan error, 1)
+	go func() {
+		rsp, err := http.Get("http://" + addr + "/slow")
+		if err == nil {
+			_ = rsp.Body.Close()
+			if rsp.StatusCode != http.StatusOK {
+				err = errors.New(rsp.Status)
+			}
+		}
+		requested <- err
+	}()
+	<-started
+	return
+}
+
+func TestServeShutdown(t *testing.T) {
+	var mu sync.Mutex
+	var calls []string
+	hook := func(name string) func(ctx context.Context) error {
+		return func(ctx context.Context) error {
+			mu.Lock()
+			defer mu.Unlock()
+			if _, found := ctx.Deadline(); !found {
+				t.Errorf("hook %s without the shutdown deadline", name)
+			}
+			calls = append(calls, name)
+			return nil
+		}
+	}
+	app := New(WithConfig(Config{}))
+	app.OnShutdown(hook("first"), hook("second"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	release, served, requested := serveSlow(t, app, ctx, "127.0.0.1:18981", 5*time.Second)
+	cancel()
+
+	select {
+	case err := <-served:
+		t.Fatalf("serve() = %v before the in-flight request finished", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	mu.Lock()
+	if len(calls) > 0 {
+		t.Errorf("hooks %v ran before the in-flight request finished", calls)
+	}
+	mu.Unlock()
+
+	close(release)
+	if err := <-requested; err != nil {
+		t.Errorf("in-flight request = %v", err)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("serve() = %v", err)
+	}
+	if !reflect.DeepEqual(calls, []string{"first", "second"}) {
+		t.Errorf("hooks ran as %v", calls)
+	}
+}
+
+func TestServeShutdownErrors(t *testing.T) {
+	hookErr := errors.New("flush failed")
+	var ran bool
+	app := New(WithConfig(Config{}))
+	app.OnShutdown(func(ctx context.Context) error {
+		return hookErr
+	}, func(ctx context.Context) error {
+		ran = true
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	release, served, requested := serveSlow(t, app, ctx, "127.0.0.1:18982", 5*time.Second)
+	cancel()
+	close(release)
+	<-requested
+	if err := <-served; err == nil || !strings.Contains(err.Error(), hookErr.Error()) {
+		t.Errorf("serve() = %v, want the hook error", err)
+	}
+	if !ran {
+		t.Error("hook after a failing one did not run")
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	release, served, requested = serveSlow(t, New(WithConfig(Config{})), ctx, "127.0.0.1:18983", 100*time.Millisecond)
+	defer func() {
+		close(release)
+		<-requested
+	}()
+	cancel()
+	select {
+	case err := <-served:
+		if err == nil || !strings.Contains(err.Error(), context.DeadlineExceeded.Error()) {
+			t.Errorf("serve() = %v, want the shutdown deadline", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("serve() did not return within the shutdown timeout")
+	}
+}
@@ -0,0 +1,204 @@
+// Package servertest runs handlers registered with server.HandlerGroup on an
+// in-process server.App, so they are tested without binding a port.
+package servertest
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/xinzf/kit/server"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// Server sends requests to the routes of an App.
+type Server struct {
+	App *server.App
+	// Header is added to every request.
+	Header http.Header
+}
+
+// New returns a Server for a new App with a zero server.Config, so the
+// server config section is not read, changed by opts.
+func New(opts ...server.Option) *Server {
+	opts = append([]server.Option{server.WithConfig(server.Config{})}, opts...)
+	return &Server{
+		App:    server.New(opts...),
+		Header: make(http.Header),
+	}
+}
+
+// Register registers handlers at the root of the App, so a method Get of
+// UserHandler answers /user_handler/get.
+func (this *Server) Register(handlers ...interface{}) *Server {
+	this.App.Group("").Register(handlers...)
+	return this
+}
+
+// Group adds a group to the App. Groups and handlers must be registered
+// before the first request, routes are bound when it is sent.
+func (this *Server) Group(path string, middlewares ...gin.HandlerFunc) *server.HandlerGroup {
+	return this.App.Group(path, middlewares...)
+}
+
+// Call posts req as JSON to path. See Do.
+func (this *Server) Call(path string, req any) (server.Response, *httptest.ResponseRecorder) {
+	return this.Do(http.MethodPost, path, req)
+}
+
+// Do sends req as a JSON body, nil sends none. The query string of path and
+// the headers of the Server are bound as well. The response is decoded from
+// the default {status, msg, data} envelope, and is zero for other bodies.
+func (this *Server) Do(method, path string, req any) (server.Response, *httptest.ResponseRecorder) {
+	var body io.Reader
+	if req != nil {
+		data, err := jsoniter.Marshal(req)
+		if err != nil {
+			panic(fmt.Sprintf("servertest: encode request failed: %s", err.Error()))
+		}
+		body = bytes.NewReader(data)
+	}
+	r := httptest.NewRequest(method, path, body)
+	if req != nil {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	return this.Send(r)
+}
+
+// Upload posts form to path, for handlers taking a *server.UploadRequest.
+func (this *Server) Upload(path string, form *Multipart) (server.Response, *httptest.ResponseRecorder) {
+	return this.Send(form.Request(path))
+}
+
+// Send serves r and decodes the response like Do.
+func (this *Server) Send(r *http.Request) (server.Response, *httptest.ResponseRecorder) {
+	for name, values := range this.Header {
+		for _, value := range values {
+			r.Header.Add(name, value)
+		}
+	}
+	w := httptest.NewRecorder()
+	this.App.Handler().ServeHTTP(w, r)
+
+	var response server.Response
+	_ = jsoniter.Unmarshal(w.Body.Bytes(), &response)
+	return response, w
+}
+
+// Multipart builds a multipart/form-data body.
+type Multipart struct {
+	fields [][2]string
+	files  []multipartFile
+}
+
+type multipartFile struct {
+	field, name string
+	content     []byte
+}
+
+func NewMultipart() *Multipart {
+	return &Multipart{}
+}
+
+// Field adds a form value, read by UploadRequest.GetPostForm.
+func (this *Multipart) Field(name, value string) *Multipart {
+	this.fields = append(this.fields, [2]string{name, value})
+	return this
+}
+
+// File adds a file under field, read by UploadRequest.Get.
+func (this *Multipart) File(field, name string, content []byte) *Multipart {
+	this.files = append(this.files, multipartFile{field: field, name: name, content: content})
+	return this
+}
+
+// Files adds files under field[], read by UploadRequest.GetFiles(field).
+// Names and contents are paired by index.
+func (this *Multipart) Files(field string, names []string, contents [][]byte) *Multipart {
+	for i, name := range names {
+		this.File(field+"[]", name, contents[i])
+	}
+	return this
+}
+
+// Encode returns the body and its Content-Type.
+func (this *Multipart) Encode() ([]byte, string) {
+	buf := &bytes.Buffer{}
+	w := multipart.NewWriter(buf)
+	for _, field := range this.fields {
+		_ = w.WriteField(field[0], field[1])
+	}
+	for _, file := range this.files {
+		part, _ := w.CreateFormFile(file.field, file.name)
+		_, _ = part.Write(file.content)
+	}
+	_ = w.Close()
+	return buf.Bytes(), w.FormDataContentType()
+}
+
+// Request returns a POST request of the form to path.
+func (this *Multipart) Request(path string) *http.Request {
+	body, contentType := this.Encode()
+	r := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+	r.Header.Set("Content-Type", contentType)
+	return r
+}
+
+// DecodeData decodes the data of response into v.
+func DecodeData(response server.Response, v any) error {
+	data, err := jsoniter.Marshal(response.Data)
+	if err != nil {
+		return err
+	}
+	return jsoniter.Unmarshal(data, v)
+}
+
+// AssertStatus fails t unless response has status.
+func AssertStatus(t testing.TB, response server.Response, status int) {
+	t.Helper()
+	if response.Status != status {
+		t.Errorf("status = %d, want %d (msg %q)", response.Status, status, response.Msg)
+	}
+}
+
+// AssertOK fails t unless response succeeded.
+func AssertOK(t testing.TB, response server.Response) {
+	t.Helper()
+	AssertStatus(t, response, 0)
+}
+
+// AssertMsg fails t unless response has msg.
+func AssertMsg(t testing.TB, response server.Response, msg string) {
+	t.Helper()
+	if response.Msg != msg {
+		t.Errorf("msg = %q, want %q", response.Msg, msg)
+	}
+}
+
+// AssertData fails t unless the data of response encodes to the same JSON
+// as want, whatever the order of object keys.
+func AssertData(t testing.TB, response server.Response, want any) {
+	t.Helper()
+	var expected any
+	data, err := jsoniter.Marshal(want)
+	if err == nil {
+		err = jsoniter.Unmarshal(data, &expected)
+	}
+	if err != nil {
+		t.Fatalf("encode expected data failed: %s", err.Error())
+	}
+
+	var actual any
+	if err := DecodeData(response, &actual); err != nil {
+		t.Fatalf("decode data failed: %s", err.Error())
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		got, _ := jsoniter.Marshal(actual)
+		t.Errorf("data = %s, want %s", got, data)
+	}
+}
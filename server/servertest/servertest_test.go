@@ -0,0 +1,93 @@
+package servertest
+
+import (
+	"errors"
+	"github.com/xinzf/kit/server"
+	"net/http"
+	"testing"
+)
+
+type greetRequest struct {
+	Name  string `json:"name" binding:"required"`
+	Token string `header:"X-Token"`
+}
+
+type greetResponse struct {
+	Greeting string `json:"greeting"`
+	Token    string `json:"token"`
+}
+
+type uploadResponse struct {
+	Owner string   `json:"owner"`
+	Names []string `json:"names"`
+	Sizes []int64  `json:"sizes"`
+}
+
+type greetHandler struct{}
+
+func (this *greetHandler) Methods() map[string][]string {
+	return map[string][]string{"Fail": {"delete"}}
+}
+
+func (this *greetHandler) Hello(req *greetRequest, rsp *greetResponse) error {
+	rsp.Greeting = "hello " + req.Name
+	rsp.Token = req.Token
+	return nil
+}
+
+func (this *greetHandler) Fail(req *greetRequest, rsp *greetResponse) (int, error) {
+	return 409, errors.New("conflict")
+}
+
+func (this *greetHandler) Upload(req *server.UploadRequest, rsp *uploadResponse) error {
+	rsp.Owner, _ = req.GetPostForm("owner")
+	avatar, err := req.Get("avatar")
+	if err != nil {
+		return err
+	}
+	rsp.Names = append(rsp.Names, avatar.FileName())
+	rsp.Sizes = append(rsp.Sizes, avatar.Size())
+	for _, file := range req.GetFiles("docs") {
+		rsp.Names = append(rsp.Names, file.FileName())
+		rsp.Sizes = append(rsp.Sizes, file.Size())
+	}
+	return nil
+}
+
+func TestServer(t *testing.T) {
+	srv := New().Register(new(greetHandler))
+	srv.Header.Set("X-Token", "secret")
+
+	rsp, w := srv.Call("/greet_handler/hello", map[string]any{"name": "kit"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("code = %d", w.Code)
+	}
+	AssertOK(t, rsp)
+	AssertData(t, rsp, greetResponse{Greeting: "hello kit", Token: "secret"})
+
+	rsp, _ = srv.Call("/greet_handler/hello", nil)
+	AssertStatus(t, rsp, 400)
+
+	rsp, _ = srv.Do(http.MethodDelete, "/greet_handler/fail", greetRequest{Name: "kit"})
+	AssertStatus(t, rsp, 409)
+	AssertMsg(t, rsp, "conflict")
+
+	form := NewMultipart().
+		Field("owner", "kit").
+		File("avatar", "avatar.png", []byte("png")).
+		Files("docs", []string{"a.txt", "b.txt"}, [][]byte{[]byte("a"), []byte("bb")})
+	rsp, _ = srv.Upload("/greet_handler/upload", form)
+	AssertOK(t, rsp)
+	var uploaded uploadResponse
+	if err := DecodeData(rsp, &uploaded); err != nil {
+		t.Fatal(err)
+	}
+	if uploaded.Owner != "kit" {
+		t.Errorf("owner = %q", uploaded.Owner)
+	}
+	AssertData(t, rsp, uploadResponse{
+		Owner: "kit",
+		Names: []string{"avatar.png", "a.txt", "b.txt"},
+		Sizes: []int64{3, 1, 2},
+	})
+}
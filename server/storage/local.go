@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Local stores objects as files under a root directory.
+type Local struct {
+	root string
+}
+
+func NewLocal(root string) *Local {
+	return &Local{root: root}
+}
+
+func (this *Local) path(key string) (string, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(this.root, filepath.FromSlash(key)), nil
+}
+
+// Put writes to a temporary file renamed to key once complete, so readers
+// never see a partial object.
+func (this *Local) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	dst, err := this.path(key)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(dst), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(f.Name())
+	}()
+
+	if _, err = io.Copy(f, contextReader{ctx: ctx, r: r}); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), dst)
+}
+
+func (this *Local) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	src, err := this.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(src)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+func (this *Local) Delete(ctx context.Context, key string) error {
+	src, err := this.path(key)
+	if err != nil {
+		return err
+	}
+	if err = os.Remove(src); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// contextReader stops a copy once ctx is done.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (this contextReader) Read(p []byte) (int, error) {
+	if err := this.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return this.r.Read(p)
+}
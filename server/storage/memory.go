@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+)
+
+// Memory keeps objects in memory, for tests.
+type Memory struct {
+	mu      sync.RWMutex
+	objects map[string][]byte
+}
+
+func NewMemory() *Memory {
+	return &Memory{objects: make(map[string][]byte)}
+}
+
+func (this *Memory) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	key, err := cleanKey(key)
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(contextReader{ctx: ctx, r: r})
+	if err != nil {
+		return err
+	}
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.objects[key] = data
+	return nil
+}
+
+func (this *Memory) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return nil, err
+	}
+	this.mu.RLock()
+	defer this.mu.RUnlock()
+	data, found := this.objects[key]
+	if !found {
+		return nil, ErrNotFound
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (this *Memory) Delete(ctx context.Context, key string) error {
+	key, err := cleanKey(key)
+	if err != nil {
+		return err
+	}
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	delete(this.objects, key)
+	return nil
+}
+
+// Keys lists the stored keys.
+func (this *Memory) Keys() []string {
+	this.mu.RLock()
+	defer this.mu.RUnlock()
+	keys := make([]string, 0, len(this.objects))
+	for key := range this.objects {
+		keys = append(keys, key)
+	}
+	return keys
+}
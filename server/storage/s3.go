@@ -0,0 +1,256 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3Config addresses a bucket of an S3 compatible service.
+type S3Config struct {
+	// Endpoint is the base URL of the service, such as
+	// https://s3.us-east-1.amazonaws.com or http://127.0.0.1:9000.
+	Endpoint     string
+	Region       string
+	Bucket       string
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	// PathStyle addresses the bucket as Endpoint/Bucket instead of
+	// Bucket.Endpoint, as MinIO and most self-hosted services expect.
+	PathStyle bool
+	// Client defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// S3 stores objects in a bucket, signing requests with AWS Signature
+// Version 4. Uploads are streamed with an unsigned payload.
+type S3 struct {
+	cfg S3Config
+}
+
+func NewS3(cfg S3Config) *S3 {
+	if cfg.Client == nil {
+		cfg.Client = http.DefaultClient
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	return &S3{cfg: cfg}
+}
+
+// Put streams r when size is known, objects of unknown size are buffered in
+// memory since S3 needs their length upfront.
+func (this *S3) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	if size < 0 {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		r, size = bytes.NewReader(data), int64(len(data))
+	}
+
+	req, err := this.request(ctx, http.MethodPut, key, io.NopCloser(r))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	if size == 0 {
+		req.Body = http.NoBody
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	rsp, err := this.do(req)
+	if err != nil {
+		return err
+	}
+	return rsp.Body.Close()
+}
+
+func (this *S3) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	req, err := this.request(ctx, http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	rsp, err := this.do(req)
+	if err != nil {
+		return nil, err
+	}
+	return rsp.Body, nil
+}
+
+func (this *S3) Delete(ctx context.Context, key string) error {
+	req, err := this.request(ctx, http.MethodDelete, key, nil)
+	if err != nil {
+		return err
+	}
+	rsp, err := this.do(req)
+	if err == ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return rsp.Body.Close()
+}
+
+func (this *S3) request(ctx context.Context, method, key string, body io.Reader) (*http.Request, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(strings.TrimRight(this.cfg.Endpoint, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("storage: invalid s3 endpoint: %s", err.Error())
+	}
+	if this.cfg.PathStyle {
+		u.Path += "/" + this.cfg.Bucket + "/" + key
+	} else {
+		u.Host = this.cfg.Bucket + "." + u.Host
+		u.Path += "/" + key
+	}
+	u.RawPath = uriEncode(u.Path, false)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	this.sign(req, "UNSIGNED-PAYLOAD", time.Now())
+	return req, nil
+}
+
+func (this *S3) do(req *http.Request) (*http.Response, error) {
+	rsp, err := this.cfg.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if rsp.StatusCode < 300 {
+		return rsp, nil
+	}
+	defer func() {
+		_ = rsp.Body.Close()
+	}()
+	if rsp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	msg, _ := io.ReadAll(io.LimitReader(rsp.Body, 1024))
+	return nil, fmt.Errorf("storage: s3 %s %s failed with %d: %s", req.Method, req.URL.Path, rsp.StatusCode, bytes.TrimSpace(msg))
+}
+
+// sign adds the Authorization header of AWS Signature Version 4.
+func (this *S3) sign(req *http.Request, payloadHash string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if this.cfg.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", this.cfg.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if name == "content-type" || strings.HasPrefix(name, "x-amz-") {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		uriEncode(req.URL.Path, false),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + this.cfg.Region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hexSHA256([]byte(canonicalRequest)),
+	}, "\n")
+	signature := hex.EncodeToString(hmacSHA256(signingKey(this.cfg.SecretKey, date, this.cfg.Region, "s3"), stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		this.cfg.AccessKey, scope, signedHeaders, signature,
+	))
+}
+
+func signingKey(secret, date, region, service string) []byte {
+	key := hmacSHA256([]byte("AWS4"+secret), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	return hmacSHA256(key, "aws4_request")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func hexSHA256(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(query))
+	for _, key := range keys {
+		values := append([]string{}, query[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			pairs = append(pairs, uriEncode(key, true)+"="+uriEncode(value, true))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// uriEncode escapes everything but the unreserved characters, and slashes
+// unless encodeSlash, as Signature Version 4 expects.
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,32 @@
+// Package storage stores uploaded files in a local directory, an S3
+// compatible bucket or memory.
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path"
+	"strings"
+)
+
+var ErrNotFound = errors.New("storage: object not found")
+
+// Storage stores objects by key, a slash separated path.
+type Storage interface {
+	// Put streams r under key. Size is the length of r, or -1 when unknown.
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Get returns the content of key, ErrNotFound when there is none.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes key, missing keys are not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// cleanKey keeps key inside the storage: /../a/./b becomes a/b.
+func cleanKey(key string) (string, error) {
+	key = strings.TrimPrefix(path.Clean("/"+key), "/")
+	if key == "" {
+		return "", errors.New("storage: empty key")
+	}
+	return key, nil
+}
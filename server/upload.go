@@ -1,98 +1,504 @@
 package server
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
-	"io/ioutil"
+	"github.com/xinzf/kit/kerrors"
+	"github.com/xinzf/kit/klog"
+	"github.com/xinzf/kit/server/storage"
+	"hash"
+	"io"
 	"mime/multipart"
 	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
 	"strings"
+	"time"
+)
+
+// UploadConfig limits the files accepted by UploadRequest handlers. Limits
+// are checked before the handler runs, failing the request with 413 or 415.
+type UploadConfig struct {
+	// MaxRequestSize bounds the whole body in bytes, 0 is unlimited.
+	MaxRequestSize int64
+	// MaxFileSize bounds every file in bytes, 0 is unlimited.
+	MaxFileSize int64
+	// MaxMemory is how much of the files is kept in memory, the others are
+	// spooled to temporary files. Fields may use 10MB more. Defaults to 32MB.
+	MaxMemory int64
+	// AllowTypes lists the accepted MIME types, sniffed from the first bytes
+	// of the files. image/* accepts any image. Empty accepts any type.
+	AllowTypes []string
+	// AllowExtensions lists the accepted file name extensions, such as .png.
+	// Empty accepts any extension.
+	AllowExtensions []string
+	// Resumable enables resumable uploads with their state in redis and their
+	// chunks in ChunkDir, unless WithChunkStore sets other stores.
+	Resumable bool
+	// ChunkDir defaults to a kit-uploads temporary directory. Chunks of
+	// uploads which expire before they complete are not removed from it.
+	ChunkDir string
+	// ChunkSize is the largest chunk of resumable uploads, 8MB by default.
+	ChunkSize int64
+	// ChunkTTL is how long resumable uploads can be completed, 24h by
+	// default.
+	ChunkTTL time.Duration
+}
+
+const (
+	defaultUploadMemory = 32 << 20
+	defaultChunkSize    = 8 << 20
+	defaultChunkTTL     = 24 * time.Hour
 )
 
 type UploadRequest struct {
 	ctx *gin.Context
+	// files and fields are read from the multipart form, or from the
+	// resumable upload once it completes.
+	files   map[string][]*File
+	fields  map[string]string
+	chunked *ChunkUpload
+	// tempFiles are the parts spooled to disk, removed after the handler.
+	tempFiles []string
+	// chunkKeys are the chunks of the completing upload, in order.
+	chunkKeys []string
+	// completed is set once the handler of a resumable upload succeeded.
+	completed bool
 }
 
 func (this *UploadRequest) Init() (int, error) {
 	return 0, nil
 }
 
+// parse walks the multipart form within cfg. Every file is checked from its
+// header and first 512 bytes, and while it is read against MaxFileSize, so a
+// rejected file is not read any further. Requests which are not multipart
+// are left to the handler.
+func (this *UploadRequest) parse(cfg UploadConfig) error {
+	r := this.ctx.Request
+	var body *limitedBody
+	if cfg.MaxRequestSize > 0 {
+		if r.ContentLength > cfg.MaxRequestSize {
+			return kerrors.Newf(http.StatusRequestEntityTooLarge, "request is larger than %d bytes", cfg.MaxRequestSize)
+		}
+		body = &limitedBody{ReadCloser: r.Body, remaining: cfg.MaxRequestSize}
+		r.Body = body
+	}
+	if cfg.MaxMemory <= 0 {
+		cfg.MaxMemory = defaultUploadMemory
+	}
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		if errors.Is(err, http.ErrNotMultipart) {
+			return nil
+		}
+		return kerrors.Wrap(err, http.StatusBadRequest, "invalid multipart form")
+	}
+
+	this.files = make(map[string][]*File)
+	this.fields = make(map[string]string)
+	values := make(url.Values)
+	memory, valueMemory := cfg.MaxMemory, cfg.MaxMemory+maxFieldMemory
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err == nil {
+			err = this.readPart(part, cfg, values, &memory, &valueMemory)
+		}
+		if err != nil {
+			// the rest of the body is not read, so the connection can't be
+			// reused
+			this.ctx.Header("Connection", "close")
+			if body != nil && body.exceeded {
+				return kerrors.Newf(http.StatusRequestEntityTooLarge, "request is larger than %d bytes", cfg.MaxRequestSize)
+			}
+			if _, coded := kerrors.FromError(err); coded {
+				return err
+			}
+			if errors.Is(err, multipart.ErrMessageTooLarge) {
+				return kerrors.Newf(http.StatusRequestEntityTooLarge, "form fields are larger than %d bytes", cfg.MaxMemory+maxFieldMemory)
+			}
+			return kerrors.Wrap(err, http.StatusBadRequest, "invalid multipart form")
+		}
+	}
+
+	for name, vals := range values {
+		this.fields[name] = vals[0]
+	}
+	// keep the fields readable from the *gin.Context, its files are only
+	// served by Get and GetFiles
+	r.PostForm = values
+	r.Form = make(url.Values)
+	for name, vals := range values {
+		r.Form[name] = append(r.Form[name], vals...)
+	}
+	for name, vals := range r.URL.Query() {
+		r.Form[name] = append(r.Form[name], vals...)
+	}
+	r.MultipartForm = &multipart.Form{Value: values, File: map[string][]*multipart.FileHeader{}}
+	return nil
+}
+
+// maxFieldMemory is how much more memory than MaxMemory the fields may use.
+const maxFieldMemory = 10 << 20
+
+// readPart reads a field into values, or checks and keeps a file in memory
+// while memory lasts and in a temporary file after.
+func (this *UploadRequest) readPart(part *multipart.Part, cfg UploadConfig, values url.Values, memory, valueMemory *int64) error {
+	// a rejected part is left unread, the next part drains an accepted one
+	name := part.FormName()
+	if name == "" {
+		return nil
+	}
+
+	if part.FileName() == "" {
+		var buf bytes.Buffer
+		n, err := io.CopyN(&buf, part, *valueMemory+1)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		if *valueMemory -= n; *valueMemory < 0 {
+			return multipart.ErrMessageTooLarge
+		}
+		values.Add(name, buf.String())
+		return nil
+	}
+
+	file := &File{name: part.FileName()}
+	if err := file.checkName(cfg); err != nil {
+		return err
+	}
+	head := make([]byte, 512)
+	n, err := io.ReadFull(part, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return err
+	}
+	head = head[:n]
+	file.mimeType = http.DetectContentType(head)
+	if err = file.checkType(cfg); err != nil {
+		return err
+	}
+
+	// read one byte past MaxFileSize to tell a file of exactly the limit
+	// from a larger one
+	var rest io.Reader = part
+	if cfg.MaxFileSize > 0 {
+		rest = io.LimitReader(part, cfg.MaxFileSize-int64(n)+1)
+	}
+	buf := bytes.NewBuffer(head)
+	size, err := io.CopyN(buf, rest, *memory-int64(n)+1)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	file.size = int64(n) + size
+	if err = file.checkName(cfg); err != nil {
+		return err
+	}
+
+	if file.size <= *memory {
+		*memory -= file.size
+		data := buf.Bytes()
+		file.open = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(data)), nil
+		}
+	} else {
+		f, err := os.CreateTemp("", "kit-upload-")
+		if err != nil {
+			return err
+		}
+		this.tempFiles = append(this.tempFiles, f.Name())
+		size, err = io.Copy(f, io.MultiReader(buf, rest))
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+		file.size = size
+		if err = file.checkName(cfg); err != nil {
+			return err
+		}
+		path := f.Name()
+		file.open = func() (io.ReadCloser, error) {
+			return os.Open(path)
+		}
+	}
+	this.files[name] = append(this.files[name], file)
+	return nil
+}
+
+// removeTempFiles removes the parts spooled to disk.
+func (this *UploadRequest) removeTempFiles() {
+	for _, path := range this.tempFiles {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			klog.Args("path", path, "err", err.Error()).Warn("Remove upload temporary file failed")
+		}
+	}
+	this.tempFiles = nil
+}
+
+var errRequestTooLarge = errors.New("request body too large")
+
+// limitedBody fails reads past remaining bytes with errRequestTooLarge. It
+// records it in exceeded, which holds however the multipart parser wraps the
+// error.
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+	exceeded  bool
+}
+
+func (this *limitedBody) Read(p []byte) (int, error) {
+	if this.exceeded {
+		return 0, errRequestTooLarge
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	// read one byte past the limit to tell a body of exactly the limit from
+	// a larger one
+	if int64(len(p)) > this.remaining+1 {
+		p = p[:this.remaining+1]
+	}
+	n, err := this.ReadCloser.Read(p)
+	if int64(n) <= this.remaining {
+		this.remaining -= int64(n)
+		return n, err
+	}
+	n = int(this.remaining)
+	this.remaining = 0
+	this.exceeded = true
+	return n, errRequestTooLarge
+}
+
+// File is an uploaded file, from a multipart form or assembled from the
+// chunks of a resumable upload.
 type File struct {
-	file        *multipart.FileHeader
-	ctx         *gin.Context
-	_dataReaded bool
-	_data       []byte
+	name     string
+	size     int64
+	open     func() (io.ReadCloser, error)
+	mimeType string
+}
+
+func newMultipartFile(header *multipart.FileHeader) *File {
+	return &File{
+		name: header.Filename,
+		size: header.Size,
+		open: func() (io.ReadCloser, error) {
+			return header.Open()
+		},
+	}
 }
 
 func (this *UploadRequest) GetPostForm(name string) (string, bool) {
+	if this.fields != nil {
+		value, found := this.fields[name]
+		return value, found
+	}
 	return this.ctx.GetPostForm(name)
 }
 
 func (this *UploadRequest) Get(name string) (*File, error) {
+	if this.files != nil {
+		if files := this.files[name]; len(files) > 0 {
+			return files[0], nil
+		}
+		return nil, http.ErrMissingFile
+	}
 	f, err := this.ctx.FormFile(name)
 	if err != nil {
 		return nil, err
 	}
-	return &File{file: f, ctx: this.ctx}, nil
+	return newMultipartFile(f), nil
 }
 
 func (this *UploadRequest) GetFiles(name string) []*File {
-	form, _ := this.ctx.MultipartForm()
-	files := form.File[fmt.Sprintf("%s[]", name)]
-
 	f := make([]*File, 0)
-	for _, file := range files {
-		f = append(f, &File{
-			file: file,
-			ctx:  this.ctx,
-		})
+	if this.files != nil {
+		return append(f, this.files[fmt.Sprintf("%s[]", name)]...)
+	}
+	form, _ := this.ctx.MultipartForm()
+	if form == nil {
+		return f
+	}
+	for _, file := range form.File[fmt.Sprintf("%s[]", name)] {
+		f = append(f, newMultipartFile(file))
 	}
 	return f
 }
 
+// check enforces the size and type limits of cfg on the file.
+func (this *File) check(cfg UploadConfig) error {
+	if err := this.checkName(cfg); err != nil {
+		return err
+	}
+	return this.checkType(cfg)
+}
+
+// checkName enforces the size and extension limits, known before the
+// content.
+func (this *File) checkName(cfg UploadConfig) error {
+	if cfg.MaxFileSize > 0 && this.Size() > cfg.MaxFileSize {
+		return kerrors.Newf(http.StatusRequestEntityTooLarge, "file %s is larger than %d bytes", this.FileName(), cfg.MaxFileSize)
+	}
+
+	if len(cfg.AllowExtensions) > 0 {
+		ext := strings.ToLower(filepath.Ext(this.FileName()))
+		allowed := false
+		for _, allow := range cfg.AllowExtensions {
+			allow = strings.ToLower(allow)
+			if !strings.HasPrefix(allow, ".") {
+				allow = "." + allow
+			}
+			if allow == ext {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return kerrors.Newf(http.StatusUnsupportedMediaType, "file %s has an unsupported extension", this.FileName())
+		}
+	}
+	return nil
+}
+
+// checkType enforces the sniffed type limits.
+func (this *File) checkType(cfg UploadConfig) error {
+	if len(cfg.AllowTypes) > 0 {
+		mimeType := this.GetMimeType()
+		if idx := strings.Index(mimeType, ";"); idx >= 0 {
+			mimeType = mimeType[:idx]
+		}
+		allowed := false
+		for _, allow := range cfg.AllowTypes {
+			allow = strings.ToLower(allow)
+			if allow == mimeType || (strings.HasSuffix(allow, "/*") && strings.HasPrefix(mimeType, allow[:len(allow)-1])) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return kerrors.Newf(http.StatusUnsupportedMediaType, "file %s has an unsupported type %s", this.FileName(), mimeType)
+		}
+	}
+	return nil
+}
+
+// Open streams the file, from memory, the temporary file it was spooled to
+// or the stored chunks. Temporary files are removed once the handler
+// returns.
+func (this *File) Open() (io.ReadCloser, error) {
+	return this.open()
+}
+
+// GetMimeType sniffs the type of the file from its first 512 bytes.
 func (this *File) GetMimeType() string {
-	data, err := this.RawBody()
+	if this.mimeType != "" {
+		return this.mimeType
+	}
+
+	this.mimeType = "application/octet-stream"
+	f, err := this.Open()
 	if err != nil {
-		return "application/octet-stream"
+		return this.mimeType
 	}
+	defer func() {
+		_ = f.Close()
+	}()
 
-	return http.DetectContentType(data)
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return this.mimeType
+	}
+	this.mimeType = http.DetectContentType(head[:n])
+	return this.mimeType
 }
 
 func (this *File) SaveFile(dst string) error {
-	return this.ctx.SaveUploadedFile(this.file, dst)
-}
+	src, err := this.Open()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = src.Close()
+	}()
 
-func (this *File) RawBody() ([]byte, error) {
-	if this._dataReaded {
-		return this._data, nil
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, src); err != nil {
+		_ = out.Close()
+		return err
 	}
+	return out.Close()
+}
 
-	this._dataReaded = true
+// Store streams the file to s under key, with its sniffed type.
+func (this *File) Store(ctx context.Context, s storage.Storage, key string) error {
+	f, err := this.Open()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	return s.Put(ctx, key, f, this.Size(), this.GetMimeType())
+}
 
-	f, err := this.file.Open()
+// Hash streams the file through h and returns its sum.
+func (this *File) Hash(h hash.Hash) ([]byte, error) {
+	f, err := this.Open()
 	if err != nil {
 		return nil, err
 	}
-
 	defer func() {
 		_ = f.Close()
 	}()
+	if _, err = io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// SHA256 returns the hex encoded SHA-256 of the file.
+func (this *File) SHA256() (string, error) {
+	sum, err := this.Hash(sha256.New())
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(sum), nil
+}
 
-	data, err := ioutil.ReadAll(f)
+// RawBody reads the whole file in memory, prefer Open or Store for large
+// files.
+func (this *File) RawBody() ([]byte, error) {
+	f, err := this.Open()
 	if err != nil {
 		return nil, err
 	}
-	this._data = data
-	return data, nil
+	defer func() {
+		_ = f.Close()
+	}()
+	return io.ReadAll(f)
 }
 
 func (this *File) Size() int64 {
-	return this.file.Size
+	return this.size
 }
 
 func (this *File) FileName() string {
-	return this.file.Filename
+	return this.name
 }
 
 func (this *File) GetExtension() string {
@@ -0,0 +1,230 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/xinzf/kit/server/storage"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+type storeResponse struct {
+	Type   string `json:"type"`
+	SHA256 string `json:"sha256"`
+}
+
+type storeHandler struct {
+	storage *storage.Memory
+}
+
+func (this *storeHandler) Avatar(req *UploadRequest, rsp *storeResponse) error {
+	file, err := req.Get("avatar")
+	if err != nil {
+		return err
+	}
+	if err = file.Store(context.Background(), this.storage, "avatars/"+file.FileName()); err != nil {
+		return err
+	}
+	rsp.Type = file.GetMimeType()
+	rsp.SHA256, err = file.SHA256()
+	return err
+}
+
+func uploadBody(name string, content []byte) (io.Reader, string) {
+	buf := &bytes.Buffer{}
+	w := multipart.NewWriter(buf)
+	part, _ := w.CreateFormFile("avatar", name)
+	_, _ = part.Write(content)
+	_ = w.Close()
+	return buf, w.FormDataContentType()
+}
+
+func TestUploadLimits(t *testing.T) {
+	png := append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{0}, 100)...)
+	sum := sha256.Sum256(png)
+
+	mem := storage.NewMemory()
+	app := New(WithConfig(Config{HTTPStatus: true, Upload: UploadConfig{
+		MaxRequestSize:  4 << 10,
+		MaxFileSize:     1 << 10,
+		AllowTypes:      []string{"image/*"},
+		AllowExtensions: []string{"png", ".JPG"},
+	}}))
+	app.Group("").Register(&storeHandler{storage: mem})
+
+	tests := []struct {
+		name    string
+		content []byte
+		code    int
+		body    string
+	}{
+		{"a.png", png, http.StatusOK, hex.EncodeToString(sum[:])},
+		{"a.txt", png, http.StatusUnsupportedMediaType, "unsupported extension"},
+		{"a.png", []byte("plain text"), http.StatusUnsupportedMediaType, "unsupported type text/plain"},
+		{"a.png", append(png, make([]byte, 2<<10)...), http.StatusRequestEntityTooLarge, "larger than 1024 bytes"},
+		{"a.png", append(png, make([]byte, 8<<10)...), http.StatusRequestEntityTooLarge, "larger than 4096 bytes"},
+	}
+	for _, tt := range tests {
+		body, contentType := uploadBody(tt.name, tt.content)
+		r := httptest.NewRequest(http.MethodPost, "/store_handler/avatar", body)
+		r.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		app.Handler().ServeHTTP(w, r)
+		if w.Code != tt.code || !strings.Contains(w.Body.String(), tt.body) {
+			t.Errorf("upload %s of %d bytes = %d %s", tt.name, len(tt.content), w.Code, w.Body.String())
+		}
+	}
+
+	// a body of unknown length is only found too large while it is read
+	body, contentType := uploadBody("a.png", append(png, make([]byte, 8<<10)...))
+	r := httptest.NewRequest(http.MethodPost, "/store_handler/avatar", body)
+	r.Header.Set("Content-Type", contentType)
+	r.ContentLength = -1
+	w := httptest.NewRecorder()
+	app.Handler().ServeHTTP(w, r)
+	if w.Code != http.StatusRequestEntityTooLarge || w.Header().Get("Connection") != "close" {
+		t.Errorf("streamed upload = %d %v %s", w.Code, w.Header(), w.Body.String())
+	}
+
+	stored, err := mem.Get(context.Background(), "avatars/a.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(stored)
+	if !bytes.Equal(data, png) {
+		t.Errorf("stored %d bytes", len(data))
+	}
+}
+
+func TestLimitedBody(t *testing.T) {
+	for size, exceeded := range map[int]bool{9: false, 10: false, 11: true} {
+		body := &limitedBody{ReadCloser: io.NopCloser(bytes.NewReader(make([]byte, size))), remaining: 10}
+		data, err := io.ReadAll(body)
+		if exceeded != (err == errRequestTooLarge) || body.exceeded != exceeded {
+			t.Errorf("read %d bytes of %d = %v, exceeded %v", len(data), size, err, body.exceeded)
+		}
+		if len(data) > 10 {
+			t.Errorf("read %d bytes past the limit", len(data))
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	for val, want := range map[any]int64{nil: 0, 512: 512, "2048": 2048, "10MB": 10 << 20, "1 gb": 1 << 30, "4KB": 4 << 10} {
+		if got := parseSize(val); got != want {
+			t.Errorf("parseSize(%v) = %d, want %d", val, got, want)
+		}
+	}
+}
+
+// readCounter counts the bytes read from r.
+type readCounter struct {
+	r io.Reader
+	n int64
+}
+
+func (this *readCounter) Read(p []byte) (int, error) {
+	n, err := this.r.Read(p)
+	this.n += int64(n)
+	return n, err
+}
+
+func TestUploadRejectsEarly(t *testing.T) {
+	png := append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{0}, 1<<20)...)
+	app := New(WithConfig(Config{HTTPStatus: true, Upload: UploadConfig{
+		MaxFileSize: 1 << 10,
+		AllowTypes:  []string{"image/*"},
+	}}))
+	app.Group("").Register(&storeHandler{storage: storage.NewMemory()})
+
+	tests := []struct {
+		name    string
+		content []byte
+		code    int
+	}{
+		{"a.png", bytes.Repeat([]byte("plain text "), 1<<17), http.StatusUnsupportedMediaType},
+		{"a.png", png, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		body, contentType := uploadBody(tt.name, tt.content)
+		counter := &readCounter{r: body}
+		r := httptest.NewRequest(http.MethodPost, "/store_handler/avatar", counter)
+		r.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		app.Handler().ServeHTTP(w, r)
+		if w.Code != tt.code || w.Header().Get("Connection") != "close" {
+			t.Errorf("upload of %d bytes = %d %v %s", len(tt.content), w.Code, w.Header(), w.Body.String())
+		}
+		if counter.n > 64<<10 {
+			t.Errorf("read %d bytes of a rejected %d bytes upload", counter.n, len(tt.content))
+		}
+	}
+}
+
+type formResponse struct {
+	Owner  string   `json:"owner"`
+	Query  string   `json:"query"`
+	Sizes  []int64  `json:"sizes"`
+	Hashes []string `json:"hashes"`
+}
+
+type formHandler struct {
+	tempFiles []string
+}
+
+func (this *formHandler) Docs(req *UploadRequest, rsp *formResponse) error {
+	this.tempFiles = append(this.tempFiles, req.tempFiles...)
+	rsp.Owner, _ = req.GetPostForm("owner")
+	rsp.Query = req.ctx.Query("page") + req.ctx.PostForm("owner")
+	for _, file := range req.GetFiles("docs") {
+		sum, err := file.SHA256()
+		if err != nil {
+			return err
+		}
+		rsp.Sizes = append(rsp.Sizes, file.Size())
+		rsp.Hashes = append(rsp.Hashes, sum)
+	}
+	return nil
+}
+
+func TestUploadForm(t *testing.T) {
+	small, large := bytes.Repeat([]byte("a"), 100), bytes.Repeat([]byte("b"), 4<<10)
+	buf := &bytes.Buffer{}
+	mw := multipart.NewWriter(buf)
+	_ = mw.WriteField("owner", "kit")
+	for i, content := range [][]byte{small, large} {
+		part, _ := mw.CreateFormFile("docs[]", fmt.Sprintf("doc%d.txt", i))
+		_, _ = part.Write(content)
+	}
+	_ = mw.Close()
+
+	h := &formHandler{}
+	app := New(WithConfig(Config{HTTPStatus: true, Upload: UploadConfig{MaxMemory: 1 << 10}}))
+	app.Group("").Register(h)
+	r := httptest.NewRequest(http.MethodPost, "/form_handler/docs?page=2", buf)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	app.Handler().ServeHTTP(w, r)
+
+	smallSum, largeSum := sha256.Sum256(small), sha256.Sum256(large)
+	for _, expect := range []string{`"owner":"kit"`, `"query":"2kit"`, `"sizes":[100,4096]`, hex.EncodeToString(smallSum[:]), hex.EncodeToString(largeSum[:])} {
+		if !strings.Contains(w.Body.String(), expect) {
+			t.Errorf("form upload = %d %s, want %s", w.Code, w.Body.String(), expect)
+		}
+	}
+	if len(h.tempFiles) != 1 {
+		t.Fatalf("spooled %d files, want the large one", len(h.tempFiles))
+	}
+	if _, err := os.Stat(h.tempFiles[0]); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("temporary file left after the handler: %v", err)
+	}
+}
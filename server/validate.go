@@ -0,0 +1,152 @@
+package server
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/locales/en"
+	"github.com/go-playground/locales/zh"
+	ut "github.com/go-playground/universal-translator"
+	"github.com/go-playground/validator/v10"
+	en_translations "github.com/go-playground/validator/v10/translations/en"
+	zh_translations "github.com/go-playground/validator/v10/translations/zh"
+	"github.com/xinzf/kit/kerrors"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// FieldError describes one field failing validation.
+type FieldError struct {
+	Field   string `json:"field"`
+	Tag     string `json:"tag"`
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message"`
+}
+
+// ValidationError is returned when a request fails its binding or validate
+// tags. It is answered with status 400 and the field errors in
+// Response.Data["errors"].
+type ValidationError struct {
+	Errors []FieldError
+}
+
+func (this *ValidationError) Error() string {
+	if len(this.Errors) == 0 {
+		return "invalid request"
+	}
+	return this.Errors[0].Message
+}
+
+type requestValidator struct {
+	validators []*validator.Validate
+	// every validator needs its own translators, translations of the same
+	// tag can only be registered once per translator.
+	translators []*ut.UniversalTranslator
+}
+
+var (
+	_validator     *requestValidator
+	_validatorOnce sync.Once
+)
+
+// getValidator returns the validator checking both binding and validate
+// tags, with english and chinese messages.
+func getValidator() *requestValidator {
+	_validatorOnce.Do(func() {
+		_validator = &requestValidator{}
+		for _, tag := range []string{"binding", "validate"} {
+			enLocale, zhLocale := en.New(), zh.New()
+			uni := ut.New(enLocale, enLocale, zhLocale)
+			enTrans, _ := uni.GetTranslator("en")
+			zhTrans, _ := uni.GetTranslator("zh")
+
+			v := validator.New()
+			v.SetTagName(tag)
+			v.RegisterTagNameFunc(fieldName)
+			_ = en_translations.RegisterDefaultTranslations(v, enTrans)
+			_ = zh_translations.RegisterDefaultTranslations(v, zhTrans)
+			_validator.validators = append(_validator.validators, v)
+			_validator.translators = append(_validator.translators, uni)
+		}
+	})
+	return _validator
+}
+
+// fieldName names fields after their json, form or uri tag, as clients know
+// them, falling back to the Go name.
+func fieldName(field reflect.StructField) string {
+	for _, tag := range []string{"json", "form", "uri", "header"} {
+		name := strings.Split(field.Tag.Get(tag), ",")[0]
+		if name == "-" {
+			return ""
+		}
+		if name != "" {
+			return name
+		}
+	}
+	return field.Name
+}
+
+// locales lists the locales asked for by Accept-Language, then the locale of
+// the App.
+func locales(c *gin.Context) []string {
+	wanted := make([]string, 0)
+	for _, lang := range strings.Split(c.GetHeader("Accept-Language"), ",") {
+		lang = strings.TrimSpace(strings.Split(lang, ";")[0])
+		if lang == "" {
+			continue
+		}
+		wanted = append(wanted, strings.ToLower(strings.Split(strings.Replace(lang, "_", "-", -1), "-")[0]))
+	}
+	if locale := appOf(c).Config().Locale; locale != "" {
+		wanted = append(wanted, locale)
+	}
+	return wanted
+}
+
+// validate checks the struct tags of req, then its Validate method.
+func (this *requestValidator) validate(c *gin.Context, req any) error {
+	if !isStructPtr(req) {
+		return nil
+	}
+
+	wanted := locales(c)
+	fieldErrors := make([]FieldError, 0)
+	for i, v := range this.validators {
+		trans, _ := this.translators[i].FindTranslator(wanted...)
+		err := v.Struct(req)
+		var errs validator.ValidationErrors
+		if !errors.As(err, &errs) {
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		for _, fe := range errs {
+			// drop the request type from the namespace: Req.items[0].name
+			field := fe.Namespace()
+			if idx := strings.Index(field, "."); idx >= 0 {
+				field = field[idx+1:]
+			}
+			fieldErrors = append(fieldErrors, FieldError{
+				Field:   field,
+				Tag:     fe.Tag(),
+				Param:   fe.Param(),
+				Message: fe.Translate(trans),
+			})
+		}
+	}
+	if len(fieldErrors) > 0 {
+		return &ValidationError{Errors: fieldErrors}
+	}
+
+	if rv, ok := req.(RequestValidator); ok {
+		if err := rv.Validate(); err != nil {
+			if _, coded := kerrors.FromError(err); coded {
+				return err
+			}
+			return kerrors.New(400, err.Error())
+		}
+	}
+	return nil
+}
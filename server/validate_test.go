@@ -0,0 +1,115 @@
+package server
+
+import (
+	"errors"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/xinzf/kit/kerrors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type validateItem struct {
+	Sku string `json:"sku" validate:"required"`
+}
+
+type validateRequest struct {
+	Name  string         `json:"name" binding:"required"`
+	Age   int            `json:"age" validate:"gte=18"`
+	Items []validateItem `json:"items" validate:"dive"`
+	Code  string         `json:"code"`
+}
+
+func (this *validateRequest) Validate() error {
+	switch this.Code {
+	case "teapot":
+		return kerrors.New(418, "code is a teapot")
+	case "bad":
+		return errors.New("code is bad")
+	}
+	return nil
+}
+
+type validateHandler struct{}
+
+func (this *validateHandler) Create(req *validateRequest, rsp *map[string]any) error {
+	return nil
+}
+
+type validateResult struct {
+	Status int    `json:"status"`
+	Msg    string `json:"msg"`
+	Data   struct {
+		Errors []FieldError `json:"errors"`
+	} `json:"data"`
+}
+
+func TestValidate(t *testing.T) {
+	app := New(WithConfig(Config{HTTPStatus: true, Locale: "zh"}))
+	app.Group("").Register(new(validateHandler))
+
+	send := func(body, language string) (int, validateResult) {
+		r := httptest.NewRequest(http.MethodPost, "/validate_handler/create", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		if language != "" {
+			r.Header.Set("Accept-Language", language)
+		}
+		w := httptest.NewRecorder()
+		app.Handler().ServeHTTP(w, r)
+		var result validateResult
+		_ = jsoniter.Unmarshal(w.Body.Bytes(), &result)
+		return w.Code, result
+	}
+
+	tests := []struct {
+		name     string
+		body     string
+		language string
+		code     int
+		errors   []FieldError
+	}{
+		{
+			name:     "binding and validate tags in english",
+			body:     `{"age":3,"items":[{"sku":"a"},{}]}`,
+			language: "en-US,en;q=0.9",
+			code:     http.StatusBadRequest,
+			errors: []FieldError{
+				{Field: "name", Tag: "required", Message: "name is a required field"},
+				{Field: "age", Tag: "gte", Param: "18", Message: "age must be 18 or greater"},
+				{Field: "items[1].sku", Tag: "required", Message: "sku is a required field"},
+			},
+		},
+		{
+			name:     "chinese from Accept-Language",
+			body:     `{"age":20}`,
+			language: "zh-CN",
+			code:     http.StatusBadRequest,
+			errors:   []FieldError{{Field: "name", Tag: "required", Message: "name为必填字段"}},
+		},
+		{
+			name:   "locale of the app",
+			body:   `{"age":20}`,
+			code:   http.StatusBadRequest,
+			errors: []FieldError{{Field: "name", Tag: "required", Message: "name为必填字段"}},
+		},
+		{name: "valid", body: `{"name":"kit","age":20}`, code: http.StatusOK},
+		{name: "coded Validate error", body: `{"name":"kit","age":20,"code":"teapot"}`, code: http.StatusTeapot},
+		{name: "Validate error", body: `{"name":"kit","age":20,"code":"bad"}`, code: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, result := send(tt.body, tt.language)
+			if code != tt.code {
+				t.Fatalf("code = %d, want %d (msg %q)", code, tt.code, result.Msg)
+			}
+			if !reflect.DeepEqual(result.Data.Errors, tt.errors) {
+				t.Errorf("errors = %+v, want %+v", result.Data.Errors, tt.errors)
+			}
+			if len(tt.errors) > 0 && result.Msg != tt.errors[0].Message {
+				t.Errorf("msg = %q, want %q", result.Msg, tt.errors[0].Message)
+			}
+		})
+	}
+}